@@ -0,0 +1,53 @@
+package commoncmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"libdb.so/nix-search/search"
+)
+
+// IndexSummary summarizes how many packages were indexed and how many package
+// sets and packages failed, e.g. "indexed 98,211 packages, 3 sets failed".
+func IndexSummary(packages int, info search.SourceInfo) string {
+	sets, failed := CountFailures(info.Failures)
+
+	summary := fmt.Sprintf("indexed %s packages", formatCount(packages))
+	if sets > 0 {
+		summary += fmt.Sprintf(", %s failed", formatNoun(sets, "set", "sets"))
+	}
+	if failed > 0 {
+		summary += fmt.Sprintf(", %s failed", formatNoun(failed, "package", "packages"))
+	}
+	return summary
+}
+
+// CountFailures returns how many of the failures are of whole package sets and
+// how many are of single packages.
+func CountFailures(failures []search.IndexFailure) (sets, packages int) {
+	for _, failure := range failures {
+		if failure.Set {
+			sets++
+		} else {
+			packages++
+		}
+	}
+	return sets, packages
+}
+
+// formatNoun formats n followed by the singular or plural noun.
+func formatNoun(n int, singular, plural string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return formatCount(n) + " " + plural
+}
+
+// formatCount formats n with thousands separators.
+func formatCount(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/nix-search/search"
+	"libdb.so/nix-search/search/searchers/blugesearcher"
+)
+
+var diffCommand = &cli.Command{
+	Name:      "diff",
+	Usage:     "print the packages that were added, removed or changed between two snapshots",
+	UsageText: "nix-dump-index diff [--json] OLD NEW",
+	Description: "OLD and NEW are either dumps written by nix-dump-index, - for standard input,\n" +
+		"or directories of nix-search indexes, such as\n" +
+		"~/.cache/nix-search/sources/nixpkgs.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "output the differences as JSON",
+		},
+	},
+	Action: diffAction,
+}
+
+func diffAction(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errors.New("expected exactly two snapshots to compare")
+	}
+
+	from, err := loadSnapshot(c.Context, c.Args().Get(0))
+	if err != nil {
+		return errors.Wrapf(err, "failed to load %q", c.Args().Get(0))
+	}
+
+	to, err := loadSnapshot(c.Context, c.Args().Get(1))
+	if err != nil {
+		return errors.Wrapf(err, "failed to load %q", c.Args().Get(1))
+	}
+
+	diffs := search.DiffPackages(from, to)
+
+	if c.Bool("json") {
+		if diffs == nil {
+			diffs = []search.PackageDiff{}
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(diffs)
+	}
+
+	printDiffs(os.Stdout, diffs)
+	return nil
+}
+
+// loadSnapshot loads the packages in a dump file or an index directory.
+func loadSnapshot(ctx context.Context, path string) (search.TopLevelPackages, error) {
+	var r io.Reader = os.Stdin
+
+	if path != "-" {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return search.TopLevelPackages{}, err
+		}
+
+		if stat.IsDir() {
+			return blugesearcher.ReadPackages(ctx, path)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return search.TopLevelPackages{}, err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	return search.DecodeDump(r)
+}
+
+func printDiffs(out io.Writer, diffs []search.PackageDiff) {
+	var added, removed, changed int
+
+	for _, diff := range diffs {
+		switch diff.Change {
+		case search.PackageAdded:
+			added++
+			fmt.Fprintf(out, "+ %s (%s)\n", diff.Path, diff.New.Version)
+		case search.PackageRemoved:
+			removed++
+			fmt.Fprintf(out, "- %s (%s)\n", diff.Path, diff.Old.Version)
+		case search.PackageChanged:
+			changed++
+			fmt.Fprintf(out, "~ %s\n", diff.Path)
+			for _, field := range diff.Fields {
+				fmt.Fprintf(out, "    %s: %q -> %q\n", field.Field, field.Old, field.New)
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "%d added, %d removed, %d changed\n", added, removed, changed)
+}
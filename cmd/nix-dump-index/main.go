@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -20,7 +22,7 @@ var (
 
 var app = cli.App{
 	Name:  "nix-dump-index",
-	Usage: "Dump a new index of packages to stdout.",
+	Usage: "Dump a new index of packages to stdout or a file.",
 	Flags: commoncmd.JoinFlags(
 		commoncmd.Flags,
 		[]cli.Flag{
@@ -49,6 +51,52 @@ var app = cli.App{
 					return nil
 				},
 			},
+			&cli.StringFlag{
+				Name:      "output",
+				Aliases:   []string{"o"},
+				Usage:     "file to write the dump to instead of stdout",
+				TakesFile: true,
+			},
+			&cli.StringFlag{
+				Name:  "compression",
+				Usage: "compression of the dump: none, gzip or zstd; defaults to guessing from the --output extension",
+				Action: func(c *cli.Context, v string) error {
+					_, err := search.ParseDumpCompression(v)
+					return err
+				},
+			},
+			&cli.StringSliceFlag{
+				Name:        "systems",
+				Usage:       "systems to record package availability for, e.g. x86_64-linux,aarch64-darwin; packages are still evaluated for the current system",
+				Destination: &opts.Systems,
+			},
+			&cli.StringFlag{
+				Name:        "config",
+				Usage:       "Nixpkgs config to evaluate packages with, either a file or an expression such as '{ allowUnfree = true; }'",
+				Destination: &opts.Config,
+			},
+			&cli.StringSliceFlag{
+				Name:        "overlay",
+				Usage:       "overlay file to apply to Nixpkgs; may be given multiple times",
+				TakesFile:   true,
+				Destination: &opts.Overlays,
+			},
+			&cli.BoolFlag{
+				Name:        "resume",
+				Usage:       "resume from where an interrupted dump of the same channel or flake left off",
+				Destination: &opts.Resume,
+			},
+			&cli.IntFlag{
+				Name:        "retries",
+				Usage:       "number of times to retry a package set that failed to evaluate",
+				Value:       opts.Retries,
+				Destination: &opts.Retries,
+			},
+			&cli.BoolFlag{
+				Name:        "strict",
+				Usage:       "fail indexing if any package set or package failed to index instead of leaving it out",
+				Destination: &opts.Strict,
+			},
 			&cli.IntFlag{
 				Name:        "max-jobs",
 				Aliases:     []string{"j"},
@@ -58,6 +106,9 @@ var app = cli.App{
 			},
 		},
 	),
+	Commands: []*cli.Command{
+		diffCommand,
+	},
 	Action: mainAction,
 }
 
@@ -79,14 +130,66 @@ func mainAction(c *cli.Context) error {
 		opts.Nixpkgs = c.String("flake")
 	}
 
-	pkgs, err := search.IndexPackages(ctx, opts)
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		opts.CheckpointDir = filepath.Join(cacheDir, "nix-search", "checkpoints")
+	}
+
+	output := c.String("output")
+
+	compression := search.DumpCompressionFromPath(output)
+	if c.IsSet("compression") {
+		compression = search.DumpCompression(c.String("compression"))
+	}
+
+	if output == "" || output == "-" {
+		return dumpPackages(ctx, os.Stdout, compression)
+	}
+
+	// Packages are written as they are evaluated, so write into a temporary
+	// file to not leave a partial dump behind if indexing fails.
+	f, err := os.CreateTemp(filepath.Dir(output), filepath.Base(output)+".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create output file")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := f.Chmod(0644); err != nil {
+		return errors.Wrap(err, "failed to create output file")
+	}
+
+	if err := dumpPackages(ctx, f, compression); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to write dump")
+	}
+
+	if err := os.Rename(f.Name(), output); err != nil {
+		return errors.Wrap(err, "failed to create output file")
+	}
+
+	return nil
+}
+
+// dumpPackages indexes packages and writes them to w while they are being
+// evaluated.
+func dumpPackages(ctx context.Context, w io.Writer, compression search.DumpCompression) error {
+	dw, err := search.NewDumpWriter(w, compression)
+	if err != nil {
+		return errors.Wrap(err, "failed to write dump")
+	}
+
+	pkgs, err := search.StreamPackages(ctx, opts, dw.Add)
 	if err != nil {
 		return errors.Wrap(err, "failed to index packages")
 	}
 
-	if err := json.NewEncoder(os.Stdout).Encode(pkgs); err != nil {
-		return errors.Wrap(err, "failed to encode packages into JSON")
+	if err := dw.Finish(pkgs); err != nil {
+		return errors.Wrap(err, "failed to write dump")
 	}
 
+	fmt.Fprintln(os.Stderr, commoncmd.IndexSummary(dw.Count(), pkgs.Info))
 	return nil
 }
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/nix-search/search"
+	"libdb.so/nix-search/search/searchers/blugesearcher"
+)
+
+var compareCommand = &cli.Command{
+	Name:      "compare",
+	Usage:     "compare package versions across sources",
+	UsageText: "nix-search compare --sources OLD,NEW[,...] [--changed] [--json] [query]",
+	Description: "Lists the version of every package matching the query in each source. Changes\n" +
+		"are classified between the first and the last source, and packages that are in\n" +
+		"neither of them are transient. Sources that are not indexed yet are indexed\n" +
+		"first, as a flake if their name contains a colon or as a channel otherwise.",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:     "sources",
+			Usage:    "comma-separated sources to compare, from oldest to newest",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "changed",
+			Usage: "only list packages whose version changed",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "output the comparison as JSON",
+		},
+	},
+	Action: compareAction,
+}
+
+// versionChange is how a package changed between the first and the last
+// compared source.
+type versionChange string
+
+const (
+	versionSame       versionChange = "same"
+	versionUpgraded   versionChange = "upgraded"
+	versionDowngraded versionChange = "downgraded"
+	versionAdded      versionChange = "added"
+	versionRemoved    versionChange = "removed"
+	// versionTransient is a package that is only in sources between the
+	// first and the last.
+	versionTransient versionChange = "transient"
+)
+
+// comparedPackage is a package as found in each compared source.
+type comparedPackage struct {
+	// Path is the attribute path relative to the source.
+	Path string `json:"path"`
+	// Versions maps source names to the version of the package in that
+	// source. Sources without the package are missing.
+	Versions map[string]string `json:"versions"`
+	Change   versionChange     `json:"change"`
+}
+
+// comparedSource is a source being compared.
+type comparedSource struct {
+	name     string
+	topLevel string
+	flake    bool
+	searcher *blugesearcher.MultiSearcher
+}
+
+// fullPath returns the full path of the package at the given path relative to
+// the source.
+func (s comparedSource) fullPath(path string) search.Path {
+	if s.flake {
+		return search.FromDotPath(s.topLevel + "#" + path)
+	}
+	return search.FromDotPath(s.topLevel + "." + path)
+}
+
+// relativePath returns the given full path relative to the source.
+func (s comparedSource) relativePath(path string) string {
+	if rel, ok := strings.CutPrefix(path, s.topLevel); ok && rel != "" && (rel[0] == '.' || rel[0] == '#') {
+		return rel[1:]
+	}
+	if i := strings.IndexAny(path, ".#"); i != -1 {
+		return path[i+1:]
+	}
+	return path
+}
+
+func compareAction(c *cli.Context) error {
+	ctx := c.Context
+
+	names := c.StringSlice("sources")
+	if len(names) < 2 {
+		return errors.New("--sources needs at least two sources to compare")
+	}
+
+	sources, err := blugesearcher.OpenSources(c.String("index-path"))
+	if err != nil {
+		return errors.Wrap(err, "failed to open index directory")
+	}
+
+	var indexed []string
+	for _, name := range names {
+		if sources.Exists(name) {
+			continue
+		}
+
+		sourceOpts := opts
+		if strings.Contains(name, ":") {
+			sourceOpts.Flake = name
+		} else {
+			sourceOpts.Flake = ""
+			sourceOpts.Nixpkgs = "<" + name + ">"
+		}
+
+		if err := indexSource(ctx, sources, name, sourceOpts, false); err != nil {
+			return errors.Wrapf(err, "failed to index source %q", name)
+		}
+		indexed = append(indexed, name)
+	}
+
+	var stale []string
+	for _, name := range names {
+		if !slices.Contains(indexed, name) {
+			stale = append(stale, name)
+		}
+	}
+	if len(stale) > 0 {
+		if err := checkStaleSources(c, sources, stale); err != nil {
+			return err
+		}
+	}
+
+	compared := make([]comparedSource, len(names))
+	for i, name := range names {
+		searcher, err := sources.Open(name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open source %q", name)
+		}
+		defer searcher.Close()
+
+		manifest, err := sources.Manifest(name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read manifest of source %q (try re-indexing it)", name)
+		}
+
+		compared[i] = comparedSource{
+			name:     name,
+			topLevel: manifest.Name,
+			flake:    manifest.Flake,
+			searcher: searcher,
+		}
+	}
+
+	searchOpts, err := searchOptsFromFlags(c)
+	if err != nil {
+		return err
+	}
+
+	query := searchQuery(c)
+	pkgs := make(map[string]*comparedPackage)
+
+	for _, source := range compared {
+		results, err := source.searcher.SearchPackages(ctx, query, searchOpts)
+		if err != nil {
+			return errors.Wrapf(err, "failed to search source %q", source.name)
+		}
+
+		for result := range results {
+			path := source.relativePath(result.Path)
+			pkg, ok := pkgs[path]
+			if !ok {
+				pkg = &comparedPackage{Path: path, Versions: make(map[string]string, len(compared))}
+				pkgs[path] = pkg
+			}
+			pkg.Versions[source.name] = result.Version
+		}
+	}
+
+	// A package may only match the query in some sources, e.g. if its
+	// description changed, so look it up directly in the others.
+	for _, pkg := range pkgs {
+		for _, source := range compared {
+			if _, ok := pkg.Versions[source.name]; ok {
+				continue
+			}
+
+			found, err := source.searcher.PackageByPath(ctx, source.fullPath(pkg.Path))
+			if err != nil {
+				if errors.Is(err, search.ErrPackageNotFound) {
+					continue
+				}
+				return errors.Wrapf(err, "failed to look up %q in source %q", pkg.Path, source.name)
+			}
+			pkg.Versions[source.name] = found.Version
+		}
+
+		pkg.Change = compareChange(pkg.Versions, names[0], names[len(names)-1])
+	}
+
+	list := make([]comparedPackage, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		if c.Bool("changed") && pkg.Change == versionSame {
+			continue
+		}
+		list = append(list, *pkg)
+	}
+	slices.SortFunc(list, func(a, b comparedPackage) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(list)
+	}
+
+	var styler textStyler
+	if !c.Bool("no-color") && termWidth() > 0 {
+		styler = styledText
+	}
+
+	printComparison(os.Stdout, styler, names, list)
+	return ctx.Err()
+}
+
+func compareChange(versions map[string]string, from, to string) versionChange {
+	oldVersion, hasOld := versions[from]
+	newVersion, hasNew := versions[to]
+
+	switch {
+	case !hasOld && !hasNew:
+		return versionTransient
+	case !hasOld && hasNew:
+		return versionAdded
+	case hasOld && !hasNew:
+		return versionRemoved
+	}
+
+	switch search.CompareVersions(oldVersion, newVersion) {
+	case -1:
+		return versionUpgraded
+	case 1:
+		return versionDowngraded
+	default:
+		return versionSame
+	}
+}
+
+func printComparison(out io.Writer, styler textStyler, names []string, pkgs []comparedPackage) {
+	const missing = "-"
+
+	widths := make([]int, len(names)+1)
+	widths[0] = len("path")
+	for i, name := range names {
+		widths[i+1] = len(name)
+	}
+	for _, pkg := range pkgs {
+		widths[0] = max(widths[0], len(pkg.Path))
+		for i, name := range names {
+			widths[i+1] = max(widths[i+1], len(pkg.Versions[name]), len(missing))
+		}
+	}
+
+	// Pad before styling, since escape sequences have no width.
+	cell := func(text string, col int) string {
+		return fmt.Sprintf("%-*s", widths[col], text)
+	}
+
+	header := []string{cell("path", 0)}
+	for i, name := range names {
+		header = append(header, cell(name, i+1))
+	}
+	fmt.Fprintln(out, styler.bold(strings.Join(header, "  ")))
+
+	for _, pkg := range pkgs {
+		row := []string{cell(pkg.Path, 0)}
+		for i, name := range names {
+			version, ok := pkg.Versions[name]
+			if !ok {
+				row = append(row, styler.dim(cell(missing, i+1)))
+				continue
+			}
+
+			text := cell(version, i+1)
+			if i == len(names)-1 {
+				switch pkg.Change {
+				case versionUpgraded, versionAdded:
+					text = styler.style(text, "\x1b[32m", "\x1b[39m") // green
+				case versionDowngraded, versionRemoved:
+					text = styler.style(text, "\x1b[31m", "\x1b[39m") // red
+				}
+			}
+			row = append(row, text)
+		}
+
+		if pkg.Change != versionSame {
+			row = append(row, styler.dim("("+string(pkg.Change)+")"))
+		}
+
+		fmt.Fprintln(out, strings.TrimRight(strings.Join(row, "  "), " "))
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestCompareChange(t *testing.T) {
+	names := []string{"nixos-23.11", "nixos-24.05", "nixos-unstable"}
+
+	tests := []struct {
+		name     string
+		versions map[string]string
+		change   versionChange
+	}{
+		{
+			name:     "same",
+			versions: map[string]string{"nixos-23.11": "1.0", "nixos-24.05": "1.1", "nixos-unstable": "1.0"},
+			change:   versionSame,
+		},
+		{
+			name:     "upgraded",
+			versions: map[string]string{"nixos-23.11": "1.9", "nixos-unstable": "1.10"},
+			change:   versionUpgraded,
+		},
+		{
+			name:     "downgraded",
+			versions: map[string]string{"nixos-23.11": "2.0", "nixos-24.05": "2.1", "nixos-unstable": "1.0"},
+			change:   versionDowngraded,
+		},
+		{
+			name:     "added",
+			versions: map[string]string{"nixos-24.05": "1.0", "nixos-unstable": "1.0"},
+			change:   versionAdded,
+		},
+		{
+			name:     "removed",
+			versions: map[string]string{"nixos-23.11": "1.0"},
+			change:   versionRemoved,
+		},
+		{
+			name:     "transient",
+			versions: map[string]string{"nixos-24.05": "1.0"},
+			change:   versionTransient,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.change, compareChange(test.versions, names[0], names[len(names)-1]))
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/nix-search/cmd/internal/commoncmd"
+	"libdb.so/nix-search/search"
+	"libdb.so/nix-search/search/searchers/blugesearcher"
+)
+
+var indexCommand = &cli.Command{
+	Name:  "index",
+	Usage: "inspect the package indexes",
+	Commands: []*cli.Command{
+		{
+			Name:      "info",
+			Usage:     "print what each index contains and when it was indexed",
+			UsageText: "nix-search [--source NAME] index info [--json]",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "output index information as JSON",
+				},
+			},
+			Action: indexInfoAction,
+		},
+	},
+}
+
+// inheritedLockFD is the file descriptor of a source lock inherited from the
+// process that spawned this one, if any. See spawnBackgroundIndex.
+var inheritedLockFD int
+
+// lockSource locks the named source for indexing, waiting for any other
+// process to finish first. An inherited lock is used as-is.
+func lockSource(sources *blugesearcher.Sources, name string) (unlock func() error, err error) {
+	if inheritedLockFD > 0 {
+		lock := os.NewFile(uintptr(inheritedLockFD), sources.SourcePath(name)+".lock")
+		inheritedLockFD = 0
+		return lock.Close, nil
+	}
+	return sources.Lock(name, true)
+}
+
+// indexSource evaluates the packages described by opts and stores them as the
+// named source. The source is locked for the duration so that concurrent
+// invocations do not race.
+func indexSource(ctx context.Context, sources *blugesearcher.Sources, name string, opts search.IndexPackagesOpts, incremental bool) error {
+	log := hclog.FromContext(ctx)
+
+	unlock, err := lockSource(sources, name)
+	if err != nil {
+		return errors.Wrap(err, "failed to lock index")
+	}
+	defer unlock()
+
+	log.Info("indexing packages", "source", name)
+
+	opts.CheckpointDir = filepath.Join(sources.Path(), "checkpoints")
+
+	if incremental {
+		pkgs, err := search.IndexPackages(ctx, opts)
+		if err != nil {
+			return errors.Wrap(err, "failed to get package index")
+		}
+
+		fmt.Fprintln(os.Stderr, commoncmd.IndexSummary(pkgs.Count(), pkgs.Info))
+
+		return storeSource(ctx, sources, name, pkgs, incremental)
+	}
+
+	// Without --incremental, there is nothing to compare against, so packages
+	// are written into the new index while the rest is still being evaluated.
+	w, err := sources.NewWriter(ctx, name)
+	if err != nil {
+		return errors.Wrap(err, "failed to create index")
+	}
+	defer w.Close()
+
+	pkgs, err := search.StreamPackages(ctx, opts, w.Add)
+	if err != nil {
+		return errors.Wrap(err, "failed to get package index")
+	}
+
+	manifest, err := w.Commit(ctx, pkgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to store indexed packages")
+	}
+
+	fmt.Fprintln(os.Stderr, commoncmd.IndexSummary(manifest.Packages, manifest.SourceInfo))
+	return nil
+}
+
+// importSource reads a dump written by nix-dump-index from the given file, or
+// from stdin if it is "-", and stores it as the named source. If name is
+// empty, the source is named after the channel or flake of the dump.
+func importSource(ctx context.Context, sources *blugesearcher.Sources, name, path string, incremental bool) error {
+	log := hclog.FromContext(ctx)
+
+	pkgs, err := readDump(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read dump %q", path)
+	}
+
+	if name == "" {
+		name = pkgs.Nixpkgs
+	}
+	if name == "" {
+		name = search.SourceName(opts)
+	}
+
+	// Older dumps do not record what they were indexed from.
+	if pkgs.Nixpkgs == "" {
+		pkgs.Nixpkgs = name
+	}
+
+	pkgs.Info.Imported = path
+
+	unlock, err := lockSource(sources, name)
+	if err != nil {
+		return errors.Wrap(err, "failed to lock index")
+	}
+	defer unlock()
+
+	log.Info("importing packages", "source", name, "dump", path)
+
+	return storeSource(ctx, sources, name, pkgs, incremental)
+}
+
+// fetchSource fetches a dump of the source described by opts from the mirror
+// and stores it as the named source. Unless revision is given, the dump of the
+// revision that the local channel or flake points to is fetched, so that the
+// index matches what the channel or flake would evaluate to.
+func fetchSource(ctx context.Context, sources *blugesearcher.Sources, name, mirror, revision string, opts search.IndexPackagesOpts, incremental bool) error {
+	log := hclog.FromContext(ctx)
+
+	if revision == "" {
+		rev, err := search.SourceRevision(ctx, opts)
+		if err != nil {
+			return errors.Wrap(err, "failed to get revision of source (use --index-revision=latest to fetch the latest dump)")
+		}
+		if rev == "" {
+			return errors.New("revision of source is unknown (use --index-revision=latest to fetch the latest dump)")
+		}
+		revision = rev
+	}
+
+	log.Info("fetching packages", "source", name, "mirror", mirror, "revision", revision)
+
+	pkgs, err := search.FetchDump(ctx, mirror, name, revision)
+	if err != nil {
+		if errors.Is(err, search.ErrDumpNotFound) && revision != search.LatestRevision {
+			return errors.Wrapf(err, "mirror has no dump of revision %q (use --index-revision=latest to fetch the latest dump)", revision)
+		}
+		return errors.Wrap(err, "failed to fetch prebuilt index")
+	}
+
+	if pkgs.Nixpkgs == "" {
+		pkgs.Nixpkgs = name
+	}
+
+	pkgs.Info.Imported = mirror
+
+	unlock, err := lockSource(sources, name)
+	if err != nil {
+		return errors.Wrap(err, "failed to lock index")
+	}
+	defer unlock()
+
+	return storeSource(ctx, sources, name, pkgs, incremental)
+}
+
+func readDump(path string) (search.TopLevelPackages, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return search.TopLevelPackages{}, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	return search.DecodeDump(r)
+}
+
+// storeSource stores the packages as the named source. The caller must hold
+// the lock of the source.
+func storeSource(ctx context.Context, sources *blugesearcher.Sources, name string, pkgs search.TopLevelPackages, incremental bool) error {
+	if incremental {
+		report, err := sources.Update(ctx, name, pkgs)
+		if err != nil {
+			return errors.Wrap(err, "failed to update indexed packages")
+		}
+		fmt.Fprintf(os.Stderr, "updated index %q: %s\n", name, report)
+	} else {
+		if err := sources.Index(ctx, name, pkgs); err != nil {
+			return errors.Wrap(err, "failed to store indexed packages")
+		}
+	}
+
+	return nil
+}
+
+type indexInfo struct {
+	Source string `json:"source"`
+	blugesearcher.Manifest
+}
+
+func indexInfoAction(c *cli.Context) error {
+	sources, err := blugesearcher.OpenSources(c.String("index-path"))
+	if err != nil {
+		return errors.Wrap(err, "failed to open index directory")
+	}
+
+	var names []string
+	if source := c.String("source"); source != "" {
+		if !sources.Exists(source) {
+			return fmt.Errorf("source %q is not indexed", source)
+		}
+		names = []string{source}
+	} else {
+		names, err = sources.Names()
+		if err != nil {
+			return errors.Wrap(err, "failed to list indexed sources")
+		}
+	}
+
+	infos := make([]indexInfo, 0, len(names))
+	for _, name := range names {
+		manifest, err := sources.Manifest(name)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return errors.Wrapf(err, "failed to read manifest of source %q", name)
+			}
+			// Indexes made by older versions have no manifest.
+		}
+		infos = append(infos, indexInfo{name, manifest})
+	}
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(infos)
+	}
+
+	for i, info := range infos {
+		if i > 0 {
+			fmt.Println()
+		}
+		printIndexInfo(os.Stdout, info)
+	}
+
+	return nil
+}
+
+func printIndexInfo(out io.Writer, info indexInfo) {
+	fmt.Fprintln(out, info.Source)
+
+	if info.Version == "" {
+		fmt.Fprintln(out, "  no manifest; re-index this source to create one")
+		return
+	}
+
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(out, "  %-12s %s\n", name+":", value)
+		}
+	}
+
+	nixpkgs := info.Nixpkgs
+	if info.Flake {
+		nixpkgs += " (flake)"
+	}
+
+	field("nixpkgs", nixpkgs)
+	field("store path", info.StorePath)
+	field("revision", info.Revision)
+	field("nar hash", info.NarHash)
+	field("system", info.System)
+	if len(info.Systems) > 1 {
+		field("systems", strings.Join(info.Systems, ", "))
+	}
+	field("config", info.Config)
+	field("overlays", strings.Join(info.Overlays, ", "))
+	field("imported", info.Imported)
+	field("indexed at", fmt.Sprintf(
+		"%s (%s ago)",
+		info.IndexedAt.Format(time.RFC3339),
+		time.Since(info.IndexedAt).Round(time.Minute)))
+	field("packages", fmt.Sprintf("%d in %d sets", info.Packages, info.Sets))
+	field("version", info.Version)
+
+	if len(info.Failures) > 0 {
+		sets, packages := commoncmd.CountFailures(info.Failures)
+		field("failed", fmt.Sprintf("%d sets, %d packages", sets, packages))
+		for _, failure := range info.Failures {
+			fmt.Fprintf(out, "    - %s: %s\n", failure.Attr, failure.Error)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -28,6 +29,8 @@ import (
 var (
 	opts        = search.DefaultIndexPackageOpts
 	searchExact = true
+	showSource  = false
+	showDetails = false
 )
 
 var app = cli.App{
@@ -48,7 +51,62 @@ var app = cli.App{
 			},
 			&cli.BoolFlag{
 				Name:  "json",
-				Usage: "output results as JSON, implies --no-{pager,color}",
+				Usage: "output results as JSON, same as --format=json",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "output format: text, json, ndjson for one JSON object per line, or fzf for one tab-separated line of path, version and description per package; all but text imply --no-{pager,color}",
+				Value: formatText,
+				Action: func(ctx *cli.Context, v string) error {
+					switch v {
+					case formatText, formatJSON, formatNDJSON, formatFzf:
+						return nil
+					default:
+						return errors.Errorf("invalid --format %q", v)
+					}
+				},
+			},
+			&cli.StringFlag{
+				Name:  "preview",
+				Usage: "print the package at the given attribute path compactly, e.g. for fzf's preview window",
+			},
+			&cli.StringFlag{
+				Name:    "bin",
+				Aliases: []string{"b"},
+				Usage:   "find packages that provide the given executable, optionally narrowed down by the query",
+			},
+			&cli.StringFlag{
+				Name:    "maintainer",
+				Aliases: []string{"m"},
+				Usage:   "list all packages maintained by the given maintainer handle or GitHub username, grouped by package set",
+			},
+			&cli.BoolFlag{
+				Name:        "details",
+				Aliases:     []string{"d"},
+				Usage:       "show homepages, maintainers, platforms and other metadata of each package",
+				Destination: &showDetails,
+			},
+			&cli.IntFlag{
+				Name:    "limit",
+				Aliases: []string{"n"},
+				Usage:   "show at most this many results; 0 shows all results",
+			},
+			&cli.IntFlag{
+				Name:  "offset",
+				Usage: "skip this many results",
+			},
+			&cli.StringFlag{
+				Name:  "sort",
+				Usage: "sort results by score, name, path or version; by default, results are shown as they are found",
+				Action: func(ctx *cli.Context, v string) error {
+					_, err := search.ParseSortOrder(v)
+					return err
+				},
+			},
+			&cli.BoolFlag{
+				Name:    "interactive",
+				Aliases: []string{"I"},
+				Usage:   "search interactively in a full-screen terminal UI, starting with the query if any",
 			},
 			&cli.BoolFlag{
 				Name:    "index",
@@ -56,12 +114,70 @@ var app = cli.App{
 				Usage:   "update the index before searching",
 				Value:   false,
 			},
+			&cli.StringFlag{
+				Name:      "index-from",
+				Usage:     "index packages from a dump written by nix-dump-index, or - for stdin, instead of evaluating them",
+				TakesFile: true,
+			},
+			&cli.StringFlag{
+				Name:    "index-url",
+				Usage:   "when indexing, fetch a prebuilt dump from this mirror URL or directory instead of evaluating packages",
+				EnvVars: []string{"NIX_SEARCH_INDEX_URL"},
+			},
+			&cli.StringFlag{
+				Name:  "index-revision",
+				Usage: "with --index-url, the revision to fetch, or \"latest\" for the latest dump; defaults to the revision of the local channel or flake",
+			},
+			&cli.BoolFlag{
+				Name:        "resume",
+				Usage:       "when indexing, resume from where an interrupted index of the same channel or flake left off",
+				Destination: &opts.Resume,
+			},
+			&cli.BoolFlag{
+				Name:  "incremental",
+				Usage: "with --index, only write packages that changed since the last index",
+			},
 			&cli.BoolFlag{
 				Name:        "exact",
 				Aliases:     []string{"e"},
 				Value:       searchExact,
 				Destination: &searchExact,
 			},
+			filterFlag("unfree", "packages with an unfree license"),
+			filterFlag("broken", "packages marked as broken"),
+			filterFlag("unsupported", "packages unsupported on this platform"),
+			filterFlag("overlay", "packages added by an overlay given to --index-overlay"),
+			&cli.StringSliceFlag{
+				Name:    "system",
+				Aliases: []string{"platform"},
+				Usage:   "only show packages available on all of the given systems, e.g. aarch64-linux; requires indexing with --index-systems",
+			},
+			&cli.StringFlag{
+				Name:    "source",
+				Aliases: []string{"s"},
+				Usage:   "name of the index to search or update; defaults to searching all indexes and to naming new indexes after the channel or flake",
+				EnvVars: []string{"NIX_SEARCH_SOURCE"},
+			},
+			&cli.StringFlag{
+				Name:    "stale",
+				Usage:   "what to do when an index is older than its channel or flake: warn, refresh, background or ignore",
+				Value:   staleWarn,
+				EnvVars: []string{"NIX_SEARCH_STALE"},
+				Action: func(ctx *cli.Context, v string) error {
+					switch v {
+					case staleWarn, staleRefresh, staleBackground, staleIgnore:
+						return nil
+					default:
+						return errors.Errorf("invalid --stale %q", v)
+					}
+				},
+			},
+			&cli.IntFlag{
+				Name:        "index-lock-fd",
+				Usage:       "file descriptor of an inherited lock on the source to index",
+				Hidden:      true,
+				Destination: &inheritedLockFD,
+			},
 			&cli.StringFlag{
 				Name:    "index-path",
 				Usage:   "path to the index directory, defaults to a directory in $XDG_CACHE_HOME",
@@ -86,6 +202,42 @@ var app = cli.App{
 				Value:       opts.Flake,
 				Destination: &opts.Flake,
 			},
+			&cli.BoolFlag{
+				Name:        "index-bins",
+				Usage:       "with --index, also index all executables of packages already in the local Nix store; slower",
+				Value:       opts.IndexBinaries,
+				Destination: &opts.IndexBinaries,
+			},
+			&cli.StringSliceFlag{
+				Name:        "index-systems",
+				Usage:       "with --index, systems to record package availability for, e.g. x86_64-linux,aarch64-darwin; packages are still evaluated for the current system",
+				EnvVars:     []string{"NIX_SEARCH_INDEX_SYSTEMS"},
+				Destination: &opts.Systems,
+			},
+			&cli.StringFlag{
+				Name:        "index-config",
+				Usage:       "with --index, Nixpkgs config to evaluate packages with, either a file or an expression such as '{ allowUnfree = true; }'",
+				EnvVars:     []string{"NIX_SEARCH_INDEX_CONFIG"},
+				Destination: &opts.Config,
+			},
+			&cli.StringSliceFlag{
+				Name:        "index-overlay",
+				Usage:       "with --index, overlay file to apply to Nixpkgs; may be given multiple times",
+				EnvVars:     []string{"NIX_SEARCH_INDEX_OVERLAYS"},
+				TakesFile:   true,
+				Destination: &opts.Overlays,
+			},
+			&cli.IntFlag{
+				Name:        "retries",
+				Usage:       "number of times to retry a package set that failed to evaluate, only used with --index",
+				Value:       opts.Retries,
+				Destination: &opts.Retries,
+			},
+			&cli.BoolFlag{
+				Name:        "strict",
+				Usage:       "fail indexing if any package set or package failed to index instead of leaving it out",
+				Destination: &opts.Strict,
+			},
 			&cli.IntFlag{
 				Name:        "max-jobs",
 				Aliases:     []string{"j"},
@@ -95,9 +247,36 @@ var app = cli.App{
 			},
 		},
 	),
+	Commands: []*cli.Command{
+		indexCommand,
+		serveCommand,
+		tuiCommand,
+		pickCommand,
+		showCommand,
+		compareCommand,
+	},
 	Action: mainAction,
 }
 
+const (
+	formatText   = "text"
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+	formatFzf    = "fzf"
+)
+
+func filterFlag(name, what string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:  name,
+		Usage: "whether to include, exclude or only show " + what,
+		Value: search.FilterInclude.String(),
+		Action: func(ctx *cli.Context, v string) error {
+			_, err := search.ParseFilter(v)
+			return err
+		},
+	}
+}
+
 func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
@@ -108,45 +287,43 @@ func main() {
 func mainAction(c *cli.Context) error {
 	ctx := c.Context
 	log := hclog.FromContext(ctx)
-	indexPath := c.String("index-path")
 
-	if !blugesearcher.Exists(indexPath) {
-		log.Info("first run or outdated index detected, will index packages")
-		c.Set("index", "true")
+	if path := c.String("preview"); path != "" {
+		return previewAction(c, path)
 	}
 
-	if c.Bool("index") {
-		if c.IsSet("flake") && c.IsSet("channel") {
-			return errors.New("cannot set both --channel and --flake")
-		}
-
-		log.Info("indexing packages")
-
-		pkgs, err := search.IndexPackages(ctx, opts)
-		if err != nil {
-			return errors.Wrap(err, "failed to get package index")
-		}
-
-		if err := blugesearcher.IndexPackages(ctx, indexPath, pkgs); err != nil {
-			return errors.Wrap(err, "failed to store indexed packages")
-		}
+	sources, indexed, err := prepareSources(c)
+	if err != nil {
+		return err
 	}
 
-	query := c.Args().First()
-	if query == "" {
+	// Without a query, only list all packages if it is for a picker.
+	query := searchQuery(c)
+	if query == "" && !c.IsSet("bin") && !c.IsSet("maintainer") && !c.Bool("interactive") && c.String("format") != formatFzf {
 		return nil
 	}
-	searcher, err := blugesearcher.Open(indexPath)
+
+	searcher, err := openSearcher(c, sources, indexed)
 	if err != nil {
-		return errors.Wrap(err, "failed to create searcher (try running with --update)")
+		return err
 	}
 	defer searcher.Close()
 
-	searchOpts := search.Opts{
-		Exact: searchExact,
+	searchOpts, err := searchOptsFromFlags(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("interactive") {
+		return runTUI(ctx, searcher, searchOpts, query, topLevelNames(sources, searcher.Names()))
 	}
 
+	format := c.String("format")
 	if c.Bool("json") {
+		format = formatJSON
+	}
+
+	if format != formatText {
 		c.Set("no-pager", "true")
 		c.Set("no-color", "true")
 	}
@@ -204,14 +381,38 @@ func mainAction(c *cli.Context) error {
 		return errors.Wrap(err, "failed to search packages")
 	}
 
+	// Line-oriented formats are streamed as results come in.
+	switch format {
+	case formatNDJSON:
+		enc := json.NewEncoder(out)
+		for pkg := range pkgsIter {
+			if err := enc.Encode(pkg); err != nil {
+				return errors.Wrap(err, "failed to write package")
+			}
+		}
+		return ctx.Err()
+	case formatFzf:
+		for pkg := range pkgsIter {
+			if err := printFzfLine(out, pkg); err != nil {
+				return errors.Wrap(err, "failed to write package")
+			}
+		}
+		return ctx.Err()
+	}
+
 	pkgs := slices.Collect(pkgsIter)
 
-	if c.Bool("json") {
+	if format == formatJSON {
 		enc := json.NewEncoder(out)
 		enc.SetIndent("", "  ")
 		return enc.Encode(pkgs)
 	}
 
+	if c.IsSet("maintainer") {
+		printPackagesBySet(out, styler, pkgs)
+		return ctx.Err()
+	}
+
 	absoluteMatches := make([]search.SearchedPackage, 0, 1)
 	pkgs = slices.DeleteFunc(pkgs, func(p search.SearchedPackage) bool {
 		dotq := "." + query
@@ -247,12 +448,293 @@ func mainAction(c *cli.Context) error {
 	return ctx.Err()
 }
 
+// prepareSources opens the index directory and indexes the selected source if
+// --index is given or if there is nothing to search yet, fetching it from
+// --index-url if given, or imports it if --index-from is given. indexed is
+// true if a source was indexed.
+func prepareSources(c *cli.Context) (sources *blugesearcher.Sources, indexed bool, err error) {
+	ctx := c.Context
+	log := hclog.FromContext(ctx)
+	source := c.String("source")
+
+	sources, err = blugesearcher.OpenSources(c.String("index-path"))
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to open index directory")
+	}
+
+	if dump := c.String("index-from"); dump != "" {
+		if c.IsSet("flake") || c.IsSet("channel") {
+			return nil, false, errors.New("cannot set --index-from with --channel or --flake")
+		}
+
+		if err := importSource(ctx, sources, source, dump, c.Bool("incremental")); err != nil {
+			return nil, false, err
+		}
+
+		return sources, true, nil
+	}
+
+	indexed = c.Bool("index")
+
+	if source != "" {
+		if !sources.Exists(source) {
+			log.Info("source not indexed yet, will index packages", "source", source)
+			indexed = true
+		}
+	} else {
+		names, err := sources.Names()
+		if err != nil {
+			return nil, false, errors.Wrap(err, "failed to list indexed sources")
+		}
+		if len(names) == 0 {
+			log.Info("first run or outdated index detected, will index packages")
+			indexed = true
+		}
+
+		warnOutdatedSources(ctx, sources)
+	}
+
+	if indexed {
+		if c.IsSet("flake") && c.IsSet("channel") {
+			return nil, false, errors.New("cannot set both --channel and --flake")
+		}
+
+		name := source
+		if name == "" {
+			name = search.SourceName(opts)
+		}
+
+		if mirror := c.String("index-url"); mirror != "" {
+			err = fetchSource(ctx, sources, name, mirror, c.String("index-revision"), opts, c.Bool("incremental"))
+		} else {
+			err = indexSource(ctx, sources, name, opts, c.Bool("incremental"))
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return sources, indexed, nil
+}
+
+// searchQuery returns the query made up of the arguments.
+func searchQuery(c *cli.Context) string {
+	return strings.Join(c.Args().Slice(), " ")
+}
+
+// warnOutdatedSources warns about sources that were indexed by an older version
+// of nix-search, since they are left out of searches until they are
+// re-indexed.
+func warnOutdatedSources(ctx context.Context, sources *blugesearcher.Sources) {
+	log := hclog.FromContext(ctx)
+
+	outdated, err := sources.Outdated()
+	if err != nil {
+		log.Debug("cannot list outdated sources", "error", err)
+		return
+	}
+
+	for _, name := range outdated {
+		log.Warn(
+			"source was indexed by an older version of nix-search and is not searched, run with --index --source to re-index it",
+			"source", name)
+	}
+}
+
+// openSearcher checks whether the selected sources are stale, unless they were
+// just indexed, and opens a searcher over them.
+func openSearcher(c *cli.Context, sources *blugesearcher.Sources, indexed bool) (*blugesearcher.MultiSearcher, error) {
+	var sourceNames []string
+	if source := c.String("source"); source != "" {
+		sourceNames = []string{source}
+	}
+
+	if !indexed {
+		if err := checkStaleSources(c, sources, sourceNames); err != nil {
+			return nil, err
+		}
+	}
+
+	searcher, err := sources.Open(sourceNames...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create searcher (try running with --index)")
+	}
+
+	showSource = len(searcher.Names()) > 1
+
+	warnUnindexedSystems(c, sources, searcher.Names())
+
+	return searcher, nil
+}
+
+// warnUnindexedSystems warns about the systems given by --system that the
+// named sources were not indexed for, since none of their packages can match.
+func warnUnindexedSystems(c *cli.Context, sources *blugesearcher.Sources, names []string) {
+	log := hclog.FromContext(c.Context)
+
+	systems := c.StringSlice("system")
+	if len(systems) == 0 {
+		return
+	}
+
+	manifests := sourceManifests(sources, names)
+	for _, system := range systems {
+		for _, manifest := range manifests {
+			if !slices.Contains(manifest.Systems, system) {
+				log.Warn(
+					"source was not indexed for system, re-index with --index-systems to include it",
+					"source", manifest.Name,
+					"system", system)
+			}
+		}
+	}
+}
+
+// sourceManifests returns the manifests of the named sources, skipping sources
+// without one.
+func sourceManifests(sources *blugesearcher.Sources, names []string) []blugesearcher.Manifest {
+	manifests := make([]blugesearcher.Manifest, 0, len(names))
+	for _, name := range names {
+		manifest, err := sources.Manifest(name)
+		if err == nil && manifest.Name != "" {
+			manifests = append(manifests, manifest)
+		}
+	}
+	return manifests
+}
+
+// topLevelNames returns the names of the top-level package sets of the named
+// sources. These are the first component of every package path.
+func topLevelNames(sources *blugesearcher.Sources, names []string) []string {
+	manifests := sourceManifests(sources, names)
+	topLevels := make([]string, len(manifests))
+	for i, manifest := range manifests {
+		topLevels[i] = manifest.Name
+	}
+	return topLevels
+}
+
+// searchOptsFromFlags returns the search options given by the flags.
+func searchOptsFromFlags(c *cli.Context) (search.Opts, error) {
+	if c.Int("limit") < 0 || c.Int("offset") < 0 {
+		return search.Opts{}, errors.New("--limit and --offset must not be negative")
+	}
+
+	searchOpts := search.Opts{
+		Exact:      searchExact,
+		Program:    c.String("bin"),
+		Maintainer: c.String("maintainer"),
+		Systems:    c.StringSlice("system"),
+		Limit:      c.Int("limit"),
+		Offset:     c.Int("offset"),
+	}
+
+	var err error
+
+	searchOpts.SortBy, err = search.ParseSortOrder(c.String("sort"))
+	if err != nil {
+		return search.Opts{}, errors.Wrap(err, "invalid --sort")
+	}
+
+	for name, filter := range map[string]*search.Filter{
+		"unfree":      &searchOpts.Unfree,
+		"broken":      &searchOpts.Broken,
+		"unsupported": &searchOpts.UnsupportedPlatform,
+		"overlay":     &searchOpts.Overlay,
+	} {
+		*filter, err = search.ParseFilter(c.String(name))
+		if err != nil {
+			return search.Opts{}, errors.Wrapf(err, "invalid --%s", name)
+		}
+	}
+
+	return searchOpts, nil
+}
+
+// previewAction prints the package at the given path with all of its details.
+// It is meant to be used by fzf's preview window, which renders colors even
+// though it is not a terminal. It only reads the existing index, since it runs
+// for every package that the cursor moves over.
+func previewAction(c *cli.Context, path string) error {
+	sources, err := blugesearcher.OpenSources(c.String("index-path"))
+	if err != nil {
+		return errors.Wrap(err, "failed to open index directory")
+	}
+
+	var sourceNames []string
+	if source := c.String("source"); source != "" {
+		sourceNames = []string{source}
+	}
+
+	searcher, err := sources.Open(sourceNames...)
+	if err != nil {
+		return errors.Wrap(err, "failed to open index")
+	}
+	defer searcher.Close()
+
+	pkg, err := searcher.PackageByPath(c.Context, search.FromDotPath(path))
+	if err != nil {
+		return errors.Wrapf(err, "failed to get package %q", path)
+	}
+
+	var styler textStyler
+	if !c.Bool("no-color") && (isatty.IsTerminal(os.Stdout.Fd()) || os.Getenv("FZF_PREVIEW_COLUMNS") != "") {
+		styler = styledText
+	}
+
+	showDetails = true
+	printPackage(os.Stdout, styler, &pkg)
+
+	return nil
+}
+
+// printFzfLine prints the package as a single line of tab-separated path,
+// version and description.
+func printFzfLine(out io.Writer, pkg search.SearchedPackage) error {
+	description := strings.Join(strings.Fields(pkg.Description), " ")
+	_, err := fmt.Fprintf(out, "%s\t%s\t%s\n", pkg.Path, pkg.Version, description)
+	return err
+}
+
 func printPackages(out io.Writer, styler textStyler, pkgs []search.SearchedPackage) {
 	for i := range pkgs {
 		printPackage(out, styler, &pkgs[i])
 	}
 }
 
+// printPackagesBySet prints the given packages grouped by the package set
+// that they are in.
+func printPackagesBySet(out io.Writer, styler textStyler, pkgs []search.SearchedPackage) {
+	parent := func(p search.SearchedPackage) string {
+		if i := strings.LastIndexAny(p.Path, ".#"); i != -1 {
+			return p.Path[:i]
+		}
+		return p.Path
+	}
+
+	slices.SortFunc(pkgs, func(a, b search.SearchedPackage) int {
+		return cmp.Or(
+			cmp.Compare(parent(a), parent(b)),
+			cmp.Compare(a.Path, b.Path),
+		)
+	})
+
+	for len(pkgs) > 0 {
+		set := parent(pkgs[0])
+		n := 1
+		for n < len(pkgs) && parent(pkgs[n]) == set {
+			n++
+		}
+
+		fmt.Fprintln(out, styler.bold(fmt.Sprintf("* %s (%d):", set, n)))
+		fmt.Fprintln(out)
+		printPackages(out, styler, pkgs[:n])
+		fmt.Fprintln(out)
+
+		pkgs = pkgs[n:]
+	}
+}
+
 func printPackage(out io.Writer, styler textStyler, pkg *search.SearchedPackage) {
 	// Use the highlighted version of the package if available.
 	if pkg.Highlighted != nil {
@@ -278,6 +760,12 @@ func printPackage(out io.Writer, styler textStyler, pkg *search.SearchedPackage)
 	if pkg.UnsupportedPlatform {
 		fmt.Fprint(out, styler.dim(" (unsupported)"))
 	}
+	if pkg.Overlay {
+		fmt.Fprint(out, styler.dim(" (overlay)"))
+	}
+	if showSource && pkg.Source != "" {
+		fmt.Fprint(out, styler.dim(" ["+pkg.Source+"]"))
+	}
 	fmt.Fprint(out, "\n")
 
 	fmt.Fprint(out, wrap(pkg.Description, "  "), "\n")
@@ -285,6 +773,44 @@ func printPackage(out io.Writer, styler textStyler, pkg *search.SearchedPackage)
 	if pkg.LongDescription != "" && pkg.Description != pkg.LongDescription {
 		fmt.Fprint(out, styleLongDescription(styler, pkg.LongDescription), "\n")
 	}
+
+	if showDetails {
+		printPackageDetails(out, styler, &pkg.Package)
+	}
+}
+
+func printPackageDetails(out io.Writer, styler textStyler, pkg *search.Package) {
+	field := func(name string, values ...string) {
+		if len(values) == 0 || values[0] == "" {
+			return
+		}
+		fmt.Fprintf(out, "  %s %s\n",
+			styler.dim(fmt.Sprintf("%-12s", name+":")),
+			strings.Join(values, ", "))
+	}
+
+	maintainers := make([]string, len(pkg.Maintainers))
+	for i, m := range pkg.Maintainers {
+		maintainers[i] = m.String()
+	}
+
+	programs := pkg.Programs
+	if pkg.MainProgram != "" && !slices.Contains(programs, pkg.MainProgram) {
+		programs = slices.Concat([]string{pkg.MainProgram}, programs)
+	}
+
+	field("homepage", pkg.Homepages...)
+	field("license", pkg.Licenses...)
+	field("programs", programs...)
+	field("maintainers", maintainers...)
+	field("teams", pkg.Teams...)
+	field("platforms", pkg.Platforms...)
+	field("systems", pkg.Systems...)
+	field("defined at", pkg.Position)
+	field("outputs", pkg.Outputs...)
+	field("out path", pkg.OutPath)
+	field("changelog", pkg.Changelog)
+	field("vulnerable", pkg.KnownVulnerabilities...)
 }
 
 var (
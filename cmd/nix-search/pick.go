@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+)
+
+var pickCommand = &cli.Command{
+	Name:      "pick",
+	Usage:     "pick a package using fzf and print its attribute path",
+	UsageText: "nix-search [options] pick [query]",
+	Description: "Feeds all packages matching the query, or all packages if there is no query,\n" +
+		"into fzf and prints the attribute path of the chosen package. The fzf command\n" +
+		"can be changed using the FZF environment variable.",
+	Action: pickAction,
+}
+
+func pickAction(c *cli.Context) error {
+	ctx := c.Context
+
+	sources, indexed, err := prepareSources(c)
+	if err != nil {
+		return err
+	}
+
+	searcher, err := openSearcher(c, sources, indexed)
+	if err != nil {
+		return err
+	}
+	defer searcher.Close()
+
+	searchOpts, err := searchOptsFromFlags(c)
+	if err != nil {
+		return err
+	}
+
+	pkgsIter, err := searcher.SearchPackages(ctx, searchQuery(c), searchOpts)
+	if err != nil {
+		return errors.Wrap(err, "failed to search packages")
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "failed to get own executable")
+	}
+
+	// fzf runs the preview command using a shell and replaces {1} with the
+	// quoted first field, which is the attribute path.
+	preview := []string{shellQuote(self), "--stale=ignore"}
+	for _, name := range []string{"index-path", "source"} {
+		if v := c.String(name); v != "" {
+			preview = append(preview, shellQuote("--"+name+"="+v))
+		}
+	}
+	preview = append(preview, "--preview", "{1}")
+
+	fzf := os.Getenv("FZF")
+	if fzf == "" {
+		fzf = "fzf"
+	}
+
+	fzfArgs := strings.Fields(fzf)
+	fzfArgs = append(fzfArgs,
+		"--delimiter=\t",
+		"--tiebreak=index",
+		"--preview", strings.Join(preview, " "),
+		"--preview-window=wrap",
+	)
+
+	var picked bytes.Buffer
+
+	fzfCmd := exec.CommandContext(ctx, fzfArgs[0], fzfArgs[1:]...)
+	fzfCmd.Stdout = &picked
+	fzfCmd.Stderr = os.Stderr
+
+	fzfIn, err := fzfCmd.StdinPipe()
+	if err != nil {
+		return errors.Wrap(err, "failed to pipe packages to fzf")
+	}
+
+	if err := fzfCmd.Start(); err != nil {
+		return errors.Wrap(err, "failed to start fzf")
+	}
+
+	// Stop feeding fzf once writing fails, which happens as soon as fzf exits
+	// without reading everything.
+	go func() {
+		defer fzfIn.Close()
+
+		w := bufio.NewWriter(fzfIn)
+		for pkg := range pkgsIter {
+			if err := printFzfLine(w, pkg); err != nil {
+				return
+			}
+		}
+		w.Flush()
+	}()
+
+	if err := fzfCmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// fzf exits with 1 if nothing matched and with 130 if the user
+			// aborted.
+			return cli.Exit("no package picked", exitErr.ExitCode())
+		}
+		return errors.Wrap(err, "fzf failed")
+	}
+
+	path, _, _ := strings.Cut(strings.TrimSpace(picked.String()), "\t")
+	if path == "" {
+		return cli.Exit("no package picked", 1)
+	}
+
+	fmt.Println(path)
+	return nil
+}
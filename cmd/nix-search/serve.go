@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/nix-search/search"
+	"libdb.so/nix-search/search/searchers/blugesearcher"
+)
+
+var serveCommand = &cli.Command{
+	Name:      "serve",
+	Usage:     "serve a JSON search API over HTTP",
+	UsageText: "nix-search [--source NAME] serve [--listen ADDR]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "listen",
+			Usage: "address to listen on",
+			Value: "localhost:8080",
+		},
+	},
+	Action: serveAction,
+}
+
+const (
+	defaultServeLimit = 50
+	maxServeLimit     = 1000
+)
+
+func serveAction(c *cli.Context) error {
+	ctx := c.Context
+	log := hclog.FromContext(ctx)
+
+	sources, err := blugesearcher.OpenSources(c.String("index-path"))
+	if err != nil {
+		return errors.Wrap(err, "failed to open index directory")
+	}
+
+	var names []string
+	if source := c.String("source"); source != "" {
+		names = []string{source}
+	}
+
+	searcher, err := sources.Open(names...)
+	if err != nil {
+		return errors.Wrap(err, "failed to open index (try running with --index)")
+	}
+	defer searcher.Close()
+
+	server := &http.Server{
+		Addr:        c.String("listen"),
+		Handler:     newSearchHandler(searcher, log),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Info("serving search API", "addr", server.Addr, "sources", searcher.Names())
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+type searchHandler struct {
+	*http.ServeMux
+	searcher *blugesearcher.MultiSearcher
+	log      hclog.Logger
+}
+
+func newSearchHandler(searcher *blugesearcher.MultiSearcher, log hclog.Logger) *searchHandler {
+	h := &searchHandler{
+		ServeMux: http.NewServeMux(),
+		searcher: searcher,
+		log:      log,
+	}
+	h.HandleFunc("GET /search", h.search)
+	h.HandleFunc("GET /package/{path...}", h.pkg)
+	h.HandleFunc("GET /healthz", h.healthz)
+	return h
+}
+
+// search handles /search?q=&limit=&offset=&sort= and responds with a JSON array
+// of search.SearchedPackage.
+func (h *searchHandler) search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := query.Get("q")
+	if q == "" {
+		h.writeError(w, http.StatusBadRequest, errors.New("missing query parameter q"))
+		return
+	}
+
+	limit, err := intParam(query.Get("limit"), defaultServeLimit)
+	if err != nil || limit < 1 || limit > maxServeLimit {
+		h.writeError(w, http.StatusBadRequest, errors.New("invalid limit"))
+		return
+	}
+
+	offset, err := intParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		h.writeError(w, http.StatusBadRequest, errors.New("invalid offset"))
+		return
+	}
+
+	sortBy := search.SortByScore
+	if v := query.Get("sort"); v != "" {
+		sortBy, err = search.ParseSortOrder(v)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	pkgsIter, err := h.searcher.SearchPackages(r.Context(), q, search.Opts{
+		Highlight: search.HighlightStyleHTML{},
+		SortBy:    sortBy,
+		Offset:    offset,
+		Limit:     limit,
+	})
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	pkgs := make([]search.SearchedPackage, 0, limit)
+	pkgs = slices.AppendSeq(pkgs, pkgsIter)
+
+	h.writeJSON(w, http.StatusOK, pkgs)
+}
+
+// pkg handles /package/{path} and responds with a single
+// search.SearchedPackage.
+func (h *searchHandler) pkg(w http.ResponseWriter, r *http.Request) {
+	path := search.FromDotPath(r.PathValue("path"))
+
+	pkg, err := h.searcher.PackageByPath(r.Context(), path)
+	if err != nil {
+		if errors.Is(err, search.ErrPackageNotFound) {
+			h.writeError(w, http.StatusNotFound, err)
+		} else {
+			h.writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, pkg)
+}
+
+func (h *searchHandler) healthz(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, map[string]any{
+		"ok":      true,
+		"sources": h.searcher.Names(),
+	})
+}
+
+func (h *searchHandler) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.log.Debug("cannot write response", "error", err)
+	}
+}
+
+func (h *searchHandler) writeError(w http.ResponseWriter, code int, err error) {
+	h.writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func intParam(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/nix-search/search"
+	"libdb.so/nix-search/search/searchers/blugesearcher"
+)
+
+var showCommand = &cli.Command{
+	Name:      "show",
+	Usage:     "print all metadata of the package at exactly the given attribute path",
+	UsageText: "nix-search [--source NAME] show [--json] PATH",
+	Description: "PATH is either a full path such as nixpkgs.python3Packages.requests, or a\n" +
+		"path relative to an indexed channel or flake such as python3Packages.requests.\n" +
+		"The exit code is 1 if no such package exists.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "output the package as JSON",
+		},
+	},
+	Action: showAction,
+}
+
+func showAction(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("expected exactly one attribute path")
+	}
+	path := c.Args().First()
+
+	sources, indexed, err := prepareSources(c)
+	if err != nil {
+		return err
+	}
+
+	searcher, err := openSearcher(c, sources, indexed)
+	if err != nil {
+		return err
+	}
+	defer searcher.Close()
+
+	pkg, err := packageByPath(c, sources, searcher, path)
+	if err != nil {
+		if errors.Is(err, search.ErrPackageNotFound) {
+			return cli.Exit(fmt.Sprintf("package %q not found", path), 1)
+		}
+		return errors.Wrapf(err, "failed to get package %q", path)
+	}
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(pkg)
+	}
+
+	var styler textStyler
+	if !c.Bool("no-color") && termWidth() > 0 {
+		styler = styledText
+	}
+
+	showDetails = true
+	printPackage(os.Stdout, styler, &pkg)
+
+	return nil
+}
+
+// packageByPath looks up the package at the given path. If the path does not
+// start with the name of an indexed channel or flake, it is looked up relative
+// to each of them in turn.
+func packageByPath(c *cli.Context, sources *blugesearcher.Sources, searcher *blugesearcher.MultiSearcher, path string) (search.SearchedPackage, error) {
+	pkg, err := searcher.PackageByPath(c.Context, search.FromDotPath(path))
+	if err == nil || !errors.Is(err, search.ErrPackageNotFound) || strings.Contains(path, "#") {
+		return pkg, err
+	}
+
+	for _, manifest := range sourceManifests(sources, searcher.Names()) {
+		fullPath := manifest.Name + "." + path
+		if manifest.Flake {
+			fullPath = manifest.Name + "#" + path
+		}
+
+		pkg, err := searcher.PackageByPath(c.Context, search.FromDotPath(fullPath))
+		if err == nil || !errors.Is(err, search.ErrPackageNotFound) {
+			return pkg, err
+		}
+	}
+
+	return search.SearchedPackage{}, search.ErrPackageNotFound
+}
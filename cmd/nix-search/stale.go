@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/nix-search/search"
+	"libdb.so/nix-search/search/searchers/blugesearcher"
+)
+
+const (
+	staleWarn       = "warn"
+	staleRefresh    = "refresh"
+	staleBackground = "background"
+	staleIgnore     = "ignore"
+)
+
+// staleCheckInterval is how long the result of checking a source for being
+// stale is reused. Checking runs nix, which may even hit the network for
+// flakes, so it is not worth doing for every search.
+const staleCheckInterval = time.Hour
+
+// checkStaleSources checks if any of the named sources (or all sources if
+// none are named) are older than the channel or flake they were indexed from,
+// and handles them according to --stale.
+func checkStaleSources(c *cli.Context, sources *blugesearcher.Sources, names []string) error {
+	ctx := c.Context
+	log := hclog.FromContext(ctx)
+
+	mode := c.String("stale")
+	if mode == staleIgnore {
+		return nil
+	}
+
+	if len(names) == 0 {
+		var err error
+
+		names, err = sources.Names()
+		if err != nil {
+			return errors.Wrap(err, "failed to list indexed sources")
+		}
+	}
+
+	for _, name := range names {
+		manifest, err := sources.Manifest(name)
+		if err != nil {
+			log.Debug("cannot read manifest, skipping staleness check", "source", name, "error", err)
+			continue
+		}
+
+		// The store path and hash of an imported source are those of the
+		// machine that evaluated it, so they cannot be compared with the local
+		// channel or flake. Such sources are refreshed by importing them again.
+		if manifest.Imported != "" {
+			log.Debug("source was imported, skipping staleness check", "source", name, "imported", manifest.Imported)
+			continue
+		}
+
+		stale, err := isStale(ctx, sources, name, manifest)
+		if err != nil {
+			log.Debug("cannot check if source is stale", "source", name, "error", err)
+			continue
+		}
+
+		if !stale {
+			continue
+		}
+
+		indexOpts := opts
+		indexOpts.Flake = ""
+		indexOpts.Nixpkgs = manifest.Nixpkgs
+		if manifest.Flake {
+			indexOpts.Flake = manifest.Nixpkgs
+		}
+		// Evaluate the same way as last time. Imported sources were skipped
+		// above, so the config and overlays are local ones rather than paths
+		// on the machine that evaluated the dump.
+		indexOpts.Systems = manifest.Systems
+		indexOpts.Config = manifest.Config
+		indexOpts.Overlays = manifest.Overlays
+
+		switch mode {
+		case staleWarn:
+			log.Warn(
+				"index is out of date, run with --index to refresh it",
+				"source", name,
+				"indexed_at", manifest.IndexedAt)
+
+		case staleRefresh:
+			if err := indexSource(ctx, sources, name, indexOpts, true); err != nil {
+				return errors.Wrapf(err, "failed to refresh source %q", name)
+			}
+
+		case staleBackground:
+			if err := spawnBackgroundIndex(c, sources, name, indexOpts); err != nil {
+				log.Warn("cannot refresh index in the background", "source", name, "error", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// isStale checks whether the named source is stale, reusing the result of the
+// last check for staleCheckInterval.
+func isStale(ctx context.Context, sources *blugesearcher.Sources, name string, manifest blugesearcher.Manifest) (bool, error) {
+	switch {
+	case time.Since(manifest.IndexedAt) < staleCheckInterval:
+		return false, nil
+	case time.Since(manifest.CheckedAt) < staleCheckInterval:
+		return manifest.Stale, nil
+	}
+
+	stale, err := search.IsStale(ctx, manifest.SourceInfo)
+	if err != nil {
+		return false, err
+	}
+
+	if err := sources.SetStale(name, stale, time.Now()); err != nil {
+		log := hclog.FromContext(ctx)
+		log.Debug("cannot record stale check", "source", name, "error", err)
+	}
+
+	return stale, nil
+}
+
+// spawnBackgroundIndex starts a detached nix-search process that re-indexes
+// the named source. Nothing is started if the source is already being
+// indexed.
+func spawnBackgroundIndex(c *cli.Context, sources *blugesearcher.Sources, name string, opts search.IndexPackagesOpts) error {
+	log := hclog.FromContext(c.Context)
+
+	lock, err := sources.LockFile(name, false)
+	if err != nil {
+		if errors.Is(err, blugesearcher.ErrLocked) {
+			log.Debug("source is already being indexed", "source", name)
+			return nil
+		}
+		return err
+	}
+	// The child process inherits the lock, so that no other invocation can
+	// start indexing in between. Closing our copy keeps it locked.
+	defer lock.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "failed to find nix-search executable")
+	}
+
+	args := []string{
+		"--index",
+		"--incremental",
+		"--source", name,
+		"--index-path", sources.Path(),
+		"--index-lock-fd", "3", // the first of ExtraFiles
+	}
+	if opts.Flake != "" {
+		args = append(args, "--flake", opts.Flake)
+	} else {
+		args = append(args, "--channel", opts.Nixpkgs)
+	}
+	if len(opts.Systems) > 0 {
+		args = append(args, "--index-systems", strings.Join(opts.Systems, ","))
+	}
+	if opts.Config != "" {
+		args = append(args, "--index-config", opts.Config)
+	}
+	for _, overlay := range opts.Overlays {
+		args = append(args, "--index-overlay", overlay)
+	}
+
+	logFile, err := os.OpenFile(
+		sources.SourcePath(name)+".log",
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to open log file")
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.ExtraFiles = []*os.File{lock}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "failed to start nix-search")
+	}
+
+	log.Warn("index is out of date, refreshing it in the background", "source", name)
+	return cmd.Process.Release()
+}
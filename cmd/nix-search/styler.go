@@ -25,6 +25,10 @@ func (s textStyler) underline(text string) string {
 	return s.styleTextBlock(text, "\x1b[4m", "\x1b[24m")
 }
 
+func (s textStyler) reverse(text string) string {
+	return s.styleTextBlock(text, "\x1b[7m", "\x1b[27m")
+}
+
 func (s textStyler) with(o textStyler) textStyler {
 	return s | o
 }
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/term"
+	"golang.org/x/text/width"
+	"libdb.so/nix-search/search"
+)
+
+var tuiCommand = &cli.Command{
+	Name:      "tui",
+	Usage:     "search interactively in a full-screen terminal UI",
+	UsageText: "nix-search [options] tui [query]",
+	Action:    tuiAction,
+}
+
+func tuiAction(c *cli.Context) error {
+	sources, indexed, err := prepareSources(c)
+	if err != nil {
+		return err
+	}
+
+	searcher, err := openSearcher(c, sources, indexed)
+	if err != nil {
+		return err
+	}
+	defer searcher.Close()
+
+	searchOpts, err := searchOptsFromFlags(c)
+	if err != nil {
+		return err
+	}
+
+	return runTUI(c.Context, searcher, searchOpts, searchQuery(c), topLevelNames(sources, searcher.Names()))
+}
+
+// tuiMaxResults is the number of results that the TUI shows if no --limit is
+// given. Nobody scrolls past this many results.
+const tuiMaxResults = 500
+
+const tuiHelp = "enter: nix-shell  ^R: nix run  ^Y: copy path  ^O: homepage  esc: quit"
+
+// tui is a full-screen terminal UI that searches as the user types.
+type tui struct {
+	searcher  search.PackagesSearcher
+	opts      search.Opts
+	topLevels []string
+	out       *bufio.Writer
+
+	query    []rune
+	results  []search.SearchedPackage
+	err      error
+	selected int
+	scroll   int
+	status   string
+
+	width  int
+	height int
+}
+
+// runTUI runs the TUI until the user quits. If the user picks a command to
+// run a package, the command is printed once the terminal is restored.
+// topLevels are the names of the top-level package sets being searched, which
+// are needed to tell channel names with dots apart from attribute paths.
+func runTUI(ctx context.Context, searcher search.PackagesSearcher, opts search.Opts, query string, topLevels []string) error {
+	stdin := int(os.Stdin.Fd())
+	if !term.IsTerminal(stdin) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("interactive mode requires a terminal")
+	}
+
+	opts.Highlight = search.HighlightStyleANSI{}
+	if opts.Limit == 0 {
+		opts.Limit = tuiMaxResults
+	}
+
+	t := &tui{
+		searcher:  searcher,
+		opts:      opts,
+		topLevels: topLevels,
+		out:       bufio.NewWriter(os.Stdout),
+		query:     []rune(query),
+	}
+
+	line, err := func() (string, error) {
+		state, err := term.MakeRaw(stdin)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to make terminal raw")
+		}
+		defer term.Restore(stdin, state)
+
+		// Switch to the alternate screen so that the user's scrollback is
+		// left untouched.
+		t.out.WriteString("\x1b[?1049h")
+		defer func() {
+			t.out.WriteString("\x1b[?1049l")
+			t.out.Flush()
+		}()
+
+		return t.run(ctx)
+	}()
+
+	if line != "" {
+		fmt.Println(line)
+	}
+
+	return err
+}
+
+func (t *tui) run(ctx context.Context) (string, error) {
+	// Read keys from a separate handle of the terminal, since unlike os.Stdin,
+	// it can be closed to stop a pending read once the TUI exits.
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open terminal")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	keys := make(chan tuiKey, 64)
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		readKeys(ctx, tty, keys)
+	}()
+	defer func() {
+		cancel()
+		tty.Close()
+		<-readerDone
+	}()
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	t.search(ctx)
+
+	for {
+		t.render()
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-winch:
+			continue
+		case key, ok := <-keys:
+			if !ok {
+				return "", nil
+			}
+
+			// Handle all keys that are already pending, such as pasted text,
+			// before searching again.
+			var searchAgain bool
+			for {
+				changed, line, quit := t.handleKey(key)
+				if quit {
+					return line, nil
+				}
+				searchAgain = searchAgain || changed
+
+				select {
+				case key, ok = <-keys:
+					if ok {
+						continue
+					}
+				default:
+				}
+				break
+			}
+
+			if searchAgain {
+				t.search(ctx)
+			}
+		}
+	}
+}
+
+// handleKey handles a single key. changed is true if the query was changed. If
+// quit is true, the TUI should exit and print line.
+func (t *tui) handleKey(key tuiKey) (changed bool, line string, quit bool) {
+	t.status = ""
+
+	switch key.name {
+	case "":
+		t.query = append(t.query, key.r)
+		return true, "", false
+	case "backspace":
+		if len(t.query) > 0 {
+			t.query = t.query[:len(t.query)-1]
+			return true, "", false
+		}
+	case "ctrl-u":
+		t.query = t.query[:0]
+		return true, "", false
+	case "ctrl-w":
+		end := len(t.query)
+		for end > 0 && unicode.IsSpace(t.query[end-1]) {
+			end--
+		}
+		for end > 0 && !unicode.IsSpace(t.query[end-1]) {
+			end--
+		}
+		t.query = t.query[:end]
+		return true, "", false
+	case "up", "ctrl-p":
+		t.move(-1)
+	case "down", "ctrl-n":
+		t.move(+1)
+	case "pgup":
+		t.move(-t.listHeight())
+	case "pgdown":
+		t.move(+t.listHeight())
+	case "enter":
+		if pkg, ok := t.current(); ok {
+			shell, _ := nixCommands(pkg.Path, t.topLevels)
+			return false, shell, true
+		}
+	case "ctrl-r":
+		if pkg, ok := t.current(); ok {
+			_, run := nixCommands(pkg.Path, t.topLevels)
+			return false, run, true
+		}
+	case "ctrl-y":
+		if pkg, ok := t.current(); ok {
+			// OSC 52 asks the terminal to set the clipboard, which also
+			// works over SSH.
+			fmt.Fprintf(t.out, "\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(pkg.Path)))
+			t.status = "copied " + pkg.Path
+		}
+	case "ctrl-o":
+		if pkg, ok := t.current(); ok {
+			if len(pkg.Homepages) == 0 {
+				t.status = pkg.Path + " has no homepage"
+			} else if err := openURL(pkg.Homepages[0]); err != nil {
+				t.status = "cannot open homepage: " + err.Error()
+			} else {
+				t.status = "opened " + pkg.Homepages[0]
+			}
+		}
+	case "esc", "ctrl-c", "ctrl-d":
+		return false, "", true
+	}
+
+	return false, "", false
+}
+
+func (t *tui) search(ctx context.Context) {
+	t.results = nil
+	t.err = nil
+	t.selected = 0
+	t.scroll = 0
+
+	query := string(t.query)
+	if strings.TrimSpace(query) == "" && t.opts.Program == "" {
+		return
+	}
+
+	pkgs, err := t.searcher.SearchPackages(ctx, query, t.opts)
+	if err != nil {
+		t.err = err
+		return
+	}
+
+	t.results = slices.Collect(pkgs)
+}
+
+func (t *tui) current() (search.SearchedPackage, bool) {
+	if t.selected < len(t.results) {
+		return t.results[t.selected], true
+	}
+	return search.SearchedPackage{}, false
+}
+
+func (t *tui) move(delta int) {
+	t.selected = max(min(t.selected+delta, len(t.results)-1), 0)
+}
+
+// listHeight returns the number of rows of the result list. The rest of the
+// screen is taken by the prompt, the detail pane and the status line.
+func (t *tui) listHeight() int {
+	return max((t.height-3)/2, 1)
+}
+
+func (t *tui) render() {
+	t.width, t.height, _ = term.GetSize(int(os.Stdout.Fd()))
+	t.width = max(t.width, 20)
+	t.height = max(t.height, 6)
+
+	listHeight := t.listHeight()
+	detailHeight := t.height - 3 - listHeight
+
+	if t.selected < t.scroll {
+		t.scroll = t.selected
+	}
+	if t.selected >= t.scroll+listHeight {
+		t.scroll = t.selected - listHeight + 1
+	}
+
+	lines := make([]string, 0, t.height)
+	lines = append(lines, styledText.bold("> ")+string(t.query))
+
+	for i := t.scroll; i < t.scroll+listHeight; i++ {
+		if i >= len(t.results) {
+			lines = append(lines, "")
+			continue
+		}
+		lines = append(lines, t.resultLine(i))
+	}
+
+	lines = append(lines, styledText.dim(strings.Repeat("─", t.width)))
+
+	detail := t.detailLines()
+	for i := range detailHeight {
+		if i < len(detail) {
+			lines = append(lines, detail[i])
+		} else {
+			lines = append(lines, "")
+		}
+	}
+
+	switch {
+	case t.err != nil:
+		lines = append(lines, styledText.dim(t.err.Error()))
+	case t.status != "":
+		lines = append(lines, styledText.dim(t.status))
+	default:
+		lines = append(lines, styledText.dim(fmt.Sprintf("%d results  %s", len(t.results), tuiHelp)))
+	}
+
+	t.out.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			t.out.WriteString("\r\n")
+		}
+		t.out.WriteString("\x1b[2K")
+		t.out.WriteString(truncateStyled(line, t.width))
+		t.out.WriteString("\x1b[0m")
+	}
+
+	// Put the cursor back at the end of the prompt.
+	fmt.Fprintf(t.out, "\x1b[1;%dH", 3+stringWidth(string(t.query)))
+	t.out.Flush()
+}
+
+func (t *tui) resultLine(i int) string {
+	pkg := t.results[i]
+	if pkg.Highlighted != nil {
+		pkg = *pkg.Highlighted
+	}
+
+	// Only reset the color of the highlight so that the selection stays.
+	path := strings.ReplaceAll(pkg.Path, "\x1b[0m", "\x1b[39m")
+
+	line := path + " " + styledText.dim("("+pkg.Version+")")
+	if showSource && pkg.Source != "" {
+		line += styledText.dim(" [" + pkg.Source + "]")
+	}
+
+	if i == t.selected {
+		return styledText.reverse("> " + line)
+	}
+	return "  " + line
+}
+
+func (t *tui) detailLines() []string {
+	pkg, ok := t.current()
+	if !ok {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	printPackage(&buf, styledText, &pkg)
+	if !showDetails {
+		printPackageDetails(&buf, styledText, &pkg.Package)
+	}
+
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// runeWidth returns the number of terminal columns that r takes up.
+func runeWidth(r rune) int {
+	switch {
+	case unicode.Is(unicode.Mn, r), unicode.Is(unicode.Me, r), r == '\u200d':
+		return 0
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+// stringWidth returns the number of terminal columns that s takes up. s must
+// not contain escape sequences.
+func stringWidth(s string) int {
+	var cols int
+	for _, r := range s {
+		cols += runeWidth(r)
+	}
+	return cols
+}
+
+// truncateStyled truncates text to the given number of columns, ignoring ANSI
+// escape sequences.
+func truncateStyled(text string, width int) string {
+	var cols int
+	for i := 0; i < len(text); {
+		if text[i] == '\x1b' {
+			// Skip until the final byte of the escape sequence.
+			j := i + 1
+			if j < len(text) && text[j] == '[' {
+				j++
+				for j < len(text) && (text[j] < 0x40 || text[j] > 0x7e) {
+					j++
+				}
+			}
+			i = j + 1
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if cols+runeWidth(r) > width {
+			return text[:i]
+		}
+
+		i += size
+		cols += runeWidth(r)
+	}
+	return text
+}
+
+// nixCommands returns the commands to get a shell with the package at the
+// given path and to run it. topLevels are the possible names of the channel
+// that path starts with.
+func nixCommands(path string, topLevels []string) (shell, run string) {
+	if flake, attr, ok := strings.Cut(path, "#"); ok {
+		ref := shellQuote(flake + "#" + attr)
+		return "nix shell " + ref, "nix run " + ref
+	}
+
+	channel, attr, _ := strings.Cut(path, ".")
+	for _, topLevel := range topLevels {
+		if len(topLevel) > len(channel) && strings.HasPrefix(path, topLevel+".") {
+			channel, attr = topLevel, path[len(topLevel)+1:]
+		}
+	}
+
+	if channel == "nixpkgs" {
+		return "nix-shell -p " + shellQuote(attr), "nix run -f '<nixpkgs>' " + shellQuote(attr)
+	}
+
+	return "nix-shell -p " + shellQuote("(import <"+channel+"> {})."+attr),
+		"nix run -f " + shellQuote("<"+channel+">") + " " + shellQuote(attr)
+}
+
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_.:/+@#=,", r))
+	}) == -1 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func openURL(url string) error {
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+
+	cmd := exec.Command(opener, url)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	go cmd.Wait()
+	return nil
+}
+
+// tuiKey is a key pressed in the TUI. Either name or r is set.
+type tuiKey struct {
+	// name is the name of a special key, e.g. "up" or "ctrl-r".
+	name string
+	// r is a printable character.
+	r rune
+}
+
+var csiKeys = map[string]string{
+	"A":  "up",
+	"B":  "down",
+	"C":  "right",
+	"D":  "left",
+	"H":  "home",
+	"F":  "end",
+	"3~": "delete",
+	"5~": "pgup",
+	"6~": "pgdown",
+}
+
+// readKeys reads keys from r into keys until r fails or ctx is canceled.
+// Closing r stops a pending read.
+func readKeys(ctx context.Context, r io.Reader, keys chan<- tuiKey) {
+	defer close(keys)
+
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for _, key := range parseKeys(buf[:n]) {
+			select {
+			case keys <- key:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func parseKeys(b []byte) []tuiKey {
+	var keys []tuiKey
+	for len(b) > 0 {
+		switch {
+		case b[0] == '\x1b' && len(b) > 2 && (b[1] == '[' || b[1] == 'O'):
+			end := 2
+			for end < len(b) && (b[end] < 0x40 || b[end] > 0x7e) {
+				end++
+			}
+			if end == len(b) {
+				return keys
+			}
+			if name, ok := csiKeys[string(b[2:end+1])]; ok {
+				keys = append(keys, tuiKey{name: name})
+			}
+			b = b[end+1:]
+		case b[0] == '\x1b':
+			keys = append(keys, tuiKey{name: "esc"})
+			b = b[1:]
+		case b[0] == '\r' || b[0] == '\n':
+			keys = append(keys, tuiKey{name: "enter"})
+			b = b[1:]
+		case b[0] == 0x7f || b[0] == '\b':
+			keys = append(keys, tuiKey{name: "backspace"})
+			b = b[1:]
+		case b[0] < 0x20:
+			keys = append(keys, tuiKey{name: "ctrl-" + string(rune('a'+b[0]-1))})
+			b = b[1:]
+		default:
+			r, size := utf8.DecodeRune(b)
+			keys = append(keys, tuiKey{r: r})
+			b = b[size:]
+		}
+	}
+	return keys
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		keys  []tuiKey
+	}{
+		{
+			name:  "runes",
+			input: "gö日",
+			keys:  []tuiKey{{r: 'g'}, {r: 'ö'}, {r: '日'}},
+		},
+		{
+			name:  "control",
+			input: "\r\n\x7f\b\x03\x15",
+			keys: []tuiKey{
+				{name: "enter"},
+				{name: "enter"},
+				{name: "backspace"},
+				{name: "backspace"},
+				{name: "ctrl-c"},
+				{name: "ctrl-u"},
+			},
+		},
+		{
+			name:  "arrows",
+			input: "\x1b[A\x1b[B\x1bOC\x1b[D",
+			keys:  []tuiKey{{name: "up"}, {name: "down"}, {name: "right"}, {name: "left"}},
+		},
+		{
+			name:  "tilde",
+			input: "\x1b[3~\x1b[5~\x1b[6~",
+			keys:  []tuiKey{{name: "delete"}, {name: "pgup"}, {name: "pgdown"}},
+		},
+		{
+			name:  "unknown-sequence",
+			input: "\x1b[1;5Ax",
+			keys:  []tuiKey{{r: 'x'}},
+		},
+		{
+			name:  "escape",
+			input: "\x1b",
+			keys:  []tuiKey{{name: "esc"}},
+		},
+		{
+			name:  "incomplete-sequence",
+			input: "a\x1b[1;",
+			keys:  []tuiKey{{r: 'a'}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.keys, parseKeys([]byte(test.input)))
+		})
+	}
+}
+
+func TestStringWidth(t *testing.T) {
+	tests := []struct {
+		input string
+		width int
+	}{
+		{"", 0},
+		{"hello", 5},
+		{"日本語", 6},
+		{"ｈｉ", 4},
+		{"é", 1},
+		{"🦀 rust", 7},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			assert.Equal(t, test.width, stringWidth(test.input))
+		})
+	}
+}
+
+func TestTruncateStyled(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		width  int
+		output string
+	}{
+		{
+			name:   "short",
+			input:  "hello",
+			width:  10,
+			output: "hello",
+		},
+		{
+			name:   "plain",
+			input:  "hello world",
+			width:  5,
+			output: "hello",
+		},
+		{
+			name:   "styled",
+			input:  "\x1b[1mhello\x1b[0m world",
+			width:  7,
+			output: "\x1b[1mhello\x1b[0m w",
+		},
+		{
+			name:   "wide",
+			input:  "日本語",
+			width:  4,
+			output: "日本",
+		},
+		{
+			name:   "wide-split",
+			input:  "日本語",
+			width:  5,
+			output: "日本",
+		},
+		{
+			name:   "zero",
+			input:  "hello",
+			width:  0,
+			output: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.output, truncateStyled(test.input, test.width))
+		})
+	}
+}
+
+func TestNixCommands(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		topLevels []string
+		shell     string
+		run       string
+	}{
+		{
+			name:  "nixpkgs",
+			path:  "nixpkgs.hello",
+			shell: "nix-shell -p hello",
+			run:   "nix run -f '<nixpkgs>' hello",
+		},
+		{
+			name:  "channel",
+			path:  "nixos-unstable.python3Packages.requests",
+			shell: "nix-shell -p '(import <nixos-unstable> {}).python3Packages.requests'",
+			run:   "nix run -f '<nixos-unstable>' python3Packages.requests",
+		},
+		{
+			name:      "dotted-channel",
+			path:      "nixos-23.11.hello",
+			topLevels: []string{"nixos-23", "nixos-23.11"},
+			shell:     "nix-shell -p '(import <nixos-23.11> {}).hello'",
+			run:       "nix run -f '<nixos-23.11>' hello",
+		},
+		{
+			name:  "flake",
+			path:  "github:NixOS/nixpkgs#hello",
+			shell: "nix shell github:NixOS/nixpkgs#hello",
+			run:   "nix run github:NixOS/nixpkgs#hello",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shell, run := nixCommands(test.path, test.topLevels)
+			assert.Equal(t, test.shell, shell)
+			assert.Equal(t, test.run, run)
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"hello", "hello"},
+		{"python3Packages.requests", "python3Packages.requests"},
+		{"github:NixOS/nixpkgs#hello", "github:NixOS/nixpkgs#hello"},
+		{"", "''"},
+		{"hello world", "'hello world'"},
+		{"<nixpkgs>", "'<nixpkgs>'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME", "'$HOME'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			assert.Equal(t, test.output, shellQuote(test.input))
+		})
+	}
+}
+
+func TestReadKeysStops(t *testing.T) {
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Fill up the channel so that readKeys blocks on sending.
+	keys := make(chan tuiKey, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readKeys(ctx, r, keys)
+	}()
+
+	_, err = w.Write([]byte("abc"))
+	assert.NoError(t, err)
+
+	select {
+	case key := <-keys:
+		assert.Equal(t, tuiKey{r: 'a'}, key)
+	case <-time.After(time.Second):
+		t.Fatal("no key read")
+	}
+
+	cancel()
+	r.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("readKeys did not stop")
+	}
+}
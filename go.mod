@@ -6,11 +6,13 @@ require (
 	github.com/alecthomas/assert/v2 v2.2.2
 	github.com/blugelabs/bluge v0.2.2
 	github.com/hashicorp/go-hclog v1.4.0
+	github.com/klauspost/compress v1.15.2
 	github.com/mattn/go-isatty v0.0.16
 	github.com/pkg/errors v0.9.1
 	github.com/urfave/cli/v3 v3.0.0-alpha2
 	golang.org/x/sys v0.24.0
 	golang.org/x/term v0.8.0
+	golang.org/x/text v0.3.8
 )
 
 require (
@@ -32,11 +34,9 @@ require (
 	github.com/fatih/color v1.13.0 // indirect
 	github.com/golang/snappy v0.0.1 // indirect
 	github.com/hexops/gotextdiff v1.0.3 // indirect
-	github.com/klauspost/compress v1.15.2 // indirect
 	github.com/mattn/go-colorable v0.1.12 // indirect
 	github.com/mschoch/smat v0.2.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/stretchr/testify v1.8.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
-	golang.org/x/text v0.3.8 // indirect
 )
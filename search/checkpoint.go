@@ -0,0 +1,164 @@
+package search
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// checkpointRecord is a finished indexing job as persisted in a checkpoint.
+type checkpointRecord struct {
+	Attrs    []string           `json:"attrs"`
+	Packages map[string]Package `json:"packages,omitempty"`
+	Sets     []string           `json:"sets,omitempty"`
+	Failures []IndexFailure     `json:"failures,omitempty"`
+}
+
+// checkpoint persists finished indexing jobs, so that an interrupted
+// IndexPackages can resume where it left off. It is an append-only file with
+// one JSON record per line, so that a crash can at most lose the last line.
+type checkpoint struct {
+	path string
+	file *os.File
+	done map[string]checkpointRecord // by jobKey
+}
+
+// checkpointPath returns the path of the checkpoint file for indexing the
+// given store path. The options that change what gets indexed are part of
+// the name, so that they never share a checkpoint.
+func checkpointPath(dir, storePath string, opts IndexPackagesOpts) string {
+	name := filepath.Base(storePath)
+	for _, system := range opts.Systems {
+		name += "-" + system
+	}
+	if opts.IndexBinaries {
+		name += "-bins"
+	}
+	if opts.Config != "" || opts.Overlays != nil {
+		// These are arbitrary expressions and paths, so only keep a hash. The
+		// files are hashed as well, so that editing an overlay in between does
+		// not resume with packages evaluated from the old one.
+		h := sha256.New()
+		json.NewEncoder(h).Encode([]any{opts.Config, opts.Overlays})
+
+		files := opts.Overlays
+		if filepath.IsAbs(opts.Config) {
+			files = append([]string{opts.Config}, files...)
+		}
+		for _, file := range files {
+			if b, err := os.ReadFile(file); err == nil {
+				h.Write(b)
+			}
+		}
+
+		name += fmt.Sprintf("-%x", h.Sum(nil)[:8])
+	}
+	return filepath.Join(dir, name+".ndjson")
+}
+
+// openCheckpoint opens the checkpoint file at the given path. If resume is
+// true, the jobs finished so far are loaded from it, otherwise it is started
+// over.
+func openCheckpoint(path string, resume bool) (*checkpoint, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create checkpoint directory")
+	}
+
+	flags := os.O_RDWR | os.O_CREATE
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open checkpoint")
+	}
+
+	c := &checkpoint{
+		path: path,
+		file: f,
+		done: make(map[string]checkpointRecord),
+	}
+
+	if err := c.load(); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "failed to load checkpoint")
+	}
+
+	return c, nil
+}
+
+// load reads all complete records, then truncates anything after them, such
+// as a line cut short by a crash, so that new records can be appended.
+func (c *checkpoint) load() error {
+	r := bufio.NewReader(c.file)
+
+	var valid int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		var rec checkpointRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			break
+		}
+
+		c.done[jobKey(rec.Attrs)] = rec
+		valid += int64(len(line))
+	}
+
+	if err := c.file.Truncate(valid); err != nil {
+		return err
+	}
+
+	_, err := c.file.Seek(valid, io.SeekStart)
+	return err
+}
+
+// lookup returns the record of the job at the given attribute path if it was
+// already finished.
+func (c *checkpoint) lookup(attrs []string) (checkpointRecord, bool) {
+	rec, ok := c.done[jobKey(attrs)]
+	return rec, ok
+}
+
+// record persists a finished job.
+func (c *checkpoint) record(rec checkpointRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = c.file.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the checkpoint file, keeping it for a later resume.
+func (c *checkpoint) Close() error {
+	return c.file.Close()
+}
+
+// Remove closes and deletes the checkpoint file once indexing has finished.
+func (c *checkpoint) Remove() error {
+	c.file.Close()
+	return os.Remove(c.path)
+}
+
+// jobKey returns a map key for the job at the given attribute path. Attribute
+// names may contain dots, so they are joined with a character that they
+// cannot contain.
+func jobKey(attrs []string) string {
+	return strings.Join(attrs, "\x00")
+}
@@ -0,0 +1,96 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints", "abc-nixpkgs.ndjson")
+
+	root := checkpointRecord{
+		Attrs:    []string{},
+		Packages: map[string]Package{"hello": {Name: "hello", Version: "2.12.1"}},
+		Sets:     []string{"python3Packages"},
+	}
+	python := checkpointRecord{
+		Attrs:    []string{"python3Packages"},
+		Packages: map[string]Package{"requests": {Name: "requests", Version: "2.32"}},
+	}
+
+	c, err := openCheckpoint(path, true)
+	assert.NoError(t, err)
+	assert.NoError(t, c.record(root))
+	assert.NoError(t, c.Close())
+
+	// Simulate a crash halfway through writing a record.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"attrs":["perlPa`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	c, err = openCheckpoint(path, true)
+	assert.NoError(t, err)
+
+	got, ok := c.lookup(root.Attrs)
+	assert.True(t, ok)
+	assert.Equal(t, root, got)
+
+	_, ok = c.lookup(python.Attrs)
+	assert.False(t, ok)
+
+	assert.NoError(t, c.record(python))
+	assert.NoError(t, c.Close())
+
+	c, err = openCheckpoint(path, true)
+	assert.NoError(t, err)
+
+	got, ok = c.lookup(python.Attrs)
+	assert.True(t, ok)
+	assert.Equal(t, python, got)
+	assert.Equal(t, 2, len(c.done))
+	assert.NoError(t, c.Close())
+
+	t.Run("restart", func(t *testing.T) {
+		c, err := openCheckpoint(path, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(c.done))
+		assert.NoError(t, c.Remove())
+
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestCheckpointPath(t *testing.T) {
+	const storePath = "/nix/store/abc-nixpkgs"
+
+	paths := map[string]bool{}
+	for _, opts := range []IndexPackagesOpts{
+		{Systems: []string{"x86_64-linux"}},
+		{Systems: []string{"x86_64-linux", "aarch64-linux"}},
+		{Systems: []string{"x86_64-linux"}, IndexBinaries: true},
+		{Systems: []string{"x86_64-linux"}, Config: "{ allowUnfree = true; }"},
+		{Systems: []string{"x86_64-linux"}, Overlays: []string{"/etc/nixpkgs/overlay.nix"}},
+	} {
+		path := checkpointPath("/tmp/checkpoints", storePath, opts)
+		assert.False(t, paths[path], "duplicate checkpoint path %q", path)
+		paths[path] = true
+	}
+
+	t.Run("overlay-contents", func(t *testing.T) {
+		overlay := filepath.Join(t.TempDir(), "overlay.nix")
+		opts := IndexPackagesOpts{Overlays: []string{overlay}}
+
+		assert.NoError(t, os.WriteFile(overlay, []byte("final: prev: { }"), 0644))
+		before := checkpointPath("/tmp/checkpoints", storePath, opts)
+		assert.Equal(t, before, checkpointPath("/tmp/checkpoints", storePath, opts))
+
+		assert.NoError(t, os.WriteFile(overlay, []byte("final: prev: { foo = 1; }"), 0644))
+		assert.NotEqual(t, before, checkpointPath("/tmp/checkpoints", storePath, opts))
+	})
+}
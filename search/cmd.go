@@ -15,8 +15,17 @@ import (
 
 // CommandError is an error that is returned when a command fails.
 type CommandError struct {
-	cmd *exec.Cmd
-	err error
+	cmd    *exec.Cmd
+	err    error
+	stderr string
+}
+
+func newCommandError(cmd *exec.Cmd, err error) *CommandError {
+	var stderr string
+	if l, ok := cmd.Stderr.(*stderrLogger); ok {
+		stderr = l.Tail()
+	}
+	return &CommandError{cmd, err, stderr}
 }
 
 func (err *CommandError) Error() string {
@@ -27,6 +36,11 @@ func (err *CommandError) Unwrap() error {
 	return err.err
 }
 
+// Stderr returns the last few lines that the command wrote to stderr.
+func (err *CommandError) Stderr() string {
+	return err.stderr
+}
+
 func execCommand(ctx context.Context, arg0 string, argv ...string) (string, error) {
 	logger := hclog.FromContext(ctx)
 	logger.Trace("executing command", "command", arg0, "args", argv)
@@ -38,10 +52,7 @@ func execCommand(ctx context.Context, arg0 string, argv ...string) (string, erro
 	cmd.Stdout = &stdout
 
 	if err := cmd.Run(); err != nil {
-		return "", &CommandError{
-			cmd: cmd,
-			err: err,
-		}
+		return "", newCommandError(cmd, err)
 	}
 
 	cmd.Stderr.(*stderrLogger).Flush()
@@ -72,10 +83,7 @@ func execCommandWriter(ctx context.Context, arg0 string, argv ...string) (io.Rea
 	}
 
 	if err := cmd.Start(); err != nil {
-		return nil, &CommandError{
-			cmd: cmd,
-			err: err,
-		}
+		return nil, newCommandError(cmd, err)
 	}
 
 	return &cmdWriter{stdout, cmd, onDone}, nil
@@ -101,19 +109,21 @@ func (c *cmdWriter) Close() error {
 	}
 
 	if err := c.cmd.Wait(); err != nil {
-		return &CommandError{
-			cmd: c.cmd,
-			err: err,
-		}
+		return newCommandError(c.cmd, err)
 	}
 
 	c.cmd.Stderr.(*stderrLogger).Flush()
 	return nil
 }
 
+// stderrTailLines is the number of lines of stderr that are kept for
+// CommandError.
+const stderrTailLines = 10
+
 type stderrLogger struct {
 	buffer bytes.Buffer
 	logger hclog.Logger
+	tail   []string
 }
 
 func newStderrLogger(ctx context.Context, cmd *exec.Cmd) *stderrLogger {
@@ -134,6 +144,23 @@ func (l *stderrLogger) Flush() error {
 			panic(fmt.Sprintf("cannot read line: %v", err))
 		}
 		l.logger.Debug(strings.TrimRight(line, "\n"))
+		l.keep(strings.TrimRight(line, "\n"))
 	}
 	return nil
 }
+
+func (l *stderrLogger) keep(line string) {
+	if len(l.tail) == stderrTailLines {
+		l.tail = append(l.tail[:0], l.tail[1:]...)
+	}
+	l.tail = append(l.tail, line)
+}
+
+// Tail returns the last lines written, including an unfinished last line.
+func (l *stderrLogger) Tail() string {
+	lines := l.tail
+	if l.buffer.Len() > 0 {
+		lines = append(lines[:len(lines):len(lines)], l.buffer.String())
+	}
+	return strings.Join(lines, "\n")
+}
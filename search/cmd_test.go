@@ -0,0 +1,25 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestStderrLoggerTail(t *testing.T) {
+	l := newStderrLogger(context.Background(), exec.Command("nix-instantiate"))
+
+	for i := 1; i <= stderrTailLines+5; i++ {
+		fmt.Fprintf(l, "line %d\n", i)
+	}
+	fmt.Fprint(l, "error: unfinished")
+
+	lines := strings.Split(l.Tail(), "\n")
+	assert.Equal(t, stderrTailLines+1, len(lines))
+	assert.Equal(t, "line 6", lines[0])
+	assert.Equal(t, "error: unfinished", lines[len(lines)-1])
+}
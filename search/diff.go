@@ -0,0 +1,121 @@
+package search
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PackageChange is how a package changed between two package sets.
+type PackageChange string
+
+const (
+	PackageAdded   PackageChange = "added"
+	PackageRemoved PackageChange = "removed"
+	PackageChanged PackageChange = "changed"
+)
+
+// PackageDiff is a package that differs between two package sets.
+type PackageDiff struct {
+	// Path is the dot-separated attribute path of the package relative to
+	// its top-level package set.
+	Path   string        `json:"path"`
+	Change PackageChange `json:"change"`
+	// Old is the package in the old set. It is nil if the package was added.
+	Old *Package `json:"old,omitempty"`
+	// New is the package in the new set. It is nil if the package was
+	// removed.
+	New *Package `json:"new,omitempty"`
+	// Fields lists the fields that changed, if Change is PackageChanged.
+	Fields []FieldDiff `json:"fields,omitempty"`
+}
+
+// FieldDiff is a package field that changed.
+type FieldDiff struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// diffedFields are the fields that DiffPackages compares, formatted as
+// strings.
+var diffedFields = []struct {
+	name  string
+	value func(Package) string
+}{
+	{"version", func(p Package) string { return p.Version }},
+	{"description", func(p Package) string { return p.Description }},
+	{"license", func(p Package) string { return strings.Join(p.Licenses, ", ") }},
+	{"mainProgram", func(p Package) string { return p.MainProgram }},
+	{"broken", func(p Package) string { return strconv.FormatBool(p.Broken) }},
+	{"unfree", func(p Package) string { return strconv.FormatBool(p.Unfree) }},
+	{"unsupportedPlatform", func(p Package) string { return strconv.FormatBool(p.UnsupportedPlatform) }},
+	{"overlay", func(p Package) string { return strconv.FormatBool(p.Overlay) }},
+	{"systems", func(p Package) string { return strings.Join(p.Systems, ", ") }},
+}
+
+// DiffPackages returns the packages that were added, removed or changed from
+// one package set to another, sorted by path. Packages are matched by their
+// path relative to the top-level package set, so the two sets may come from
+// differently named channels or flakes.
+func DiffPackages(from, to TopLevelPackages) []PackageDiff {
+	oldPkgs := relativePackages(from)
+	newPkgs := relativePackages(to)
+
+	var diffs []PackageDiff
+
+	for path, oldPkg := range oldPkgs {
+		newPkg, ok := newPkgs[path]
+		if !ok {
+			diffs = append(diffs, PackageDiff{
+				Path:   path,
+				Change: PackageRemoved,
+				Old:    &oldPkg,
+			})
+			continue
+		}
+
+		var fields []FieldDiff
+		for _, field := range diffedFields {
+			if o, n := field.value(oldPkg), field.value(newPkg); o != n {
+				fields = append(fields, FieldDiff{field.name, o, n})
+			}
+		}
+
+		if len(fields) > 0 {
+			diffs = append(diffs, PackageDiff{
+				Path:   path,
+				Change: PackageChanged,
+				Old:    &oldPkg,
+				New:    &newPkg,
+				Fields: fields,
+			})
+		}
+	}
+
+	for path, newPkg := range newPkgs {
+		if _, ok := oldPkgs[path]; !ok {
+			diffs = append(diffs, PackageDiff{
+				Path:   path,
+				Change: PackageAdded,
+				New:    &newPkg,
+			})
+		}
+	}
+
+	slices.SortFunc(diffs, func(a, b PackageDiff) int {
+		return cmp.Compare(a.Path, b.Path)
+	})
+
+	return diffs
+}
+
+func relativePackages(pkgs TopLevelPackages) map[string]Package {
+	m := make(map[string]Package, pkgs.Count())
+	pkgs.Walk(func(path Path, pkg Package) bool {
+		m[strings.Join(path.Parts()[1:], ".")] = pkg
+		return true
+	})
+	return m
+}
@@ -0,0 +1,408 @@
+package search
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// DumpVersion is the schema version of dumps written by DumpWriter. It is
+// bumped whenever the dump format changes in a way that older versions cannot
+// read.
+//
+// A dump lists each package and package set along with its path in
+// "derivations", so that dumps can be written while packages are still being
+// evaluated.
+const DumpVersion = 1
+
+// DumpCompression is the compression of a dump.
+type DumpCompression string
+
+const (
+	DumpUncompressed DumpCompression = "none"
+	DumpGzip         DumpCompression = "gzip"
+	DumpZstd         DumpCompression = "zstd"
+)
+
+// ParseDumpCompression parses a compression name as accepted by EncodeDump.
+func ParseDumpCompression(s string) (DumpCompression, error) {
+	switch c := DumpCompression(s); c {
+	case DumpUncompressed, DumpGzip, DumpZstd:
+		return c, nil
+	default:
+		return "", errors.Errorf("unknown dump compression %q", s)
+	}
+}
+
+// DumpCompressionFromPath guesses the compression of a dump from the extension
+// of its file name.
+func DumpCompressionFromPath(path string) DumpCompression {
+	switch filepath.Ext(path) {
+	case ".gz":
+		return DumpGzip
+	case ".zst", ".zstd":
+		return DumpZstd
+	default:
+		return DumpUncompressed
+	}
+}
+
+// Magic numbers at the start of compressed dumps.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// dumpDerivationJSON is the JSON representation of a package or package set
+// in the "derivations" of a dump.
+type dumpDerivationJSON struct {
+	// Path is the path relative to the top-level package set.
+	Path []string `json:"path"`
+	Type string   `json:"_type"`
+	*Package
+}
+
+// DumpWriter writes a dump while packages are added to it, so that they never
+// have to be held in memory all at once.
+type DumpWriter struct {
+	out      io.WriteCloser // compressor
+	w        *bufio.Writer
+	enc      *json.Encoder
+	packages int
+	n        int // derivations written
+}
+
+// NewDumpWriter starts writing a dump with the given compression to w. The
+// dump must be completed with Finish.
+func NewDumpWriter(w io.Writer, compression DumpCompression) (*DumpWriter, error) {
+	var out io.WriteCloser
+
+	switch compression {
+	case DumpUncompressed, "":
+		out = nopWriteCloser{w}
+	case DumpGzip:
+		out = gzip.NewWriter(w)
+	case DumpZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		out = zw
+	default:
+		return nil, errors.Errorf("unknown dump compression %q", compression)
+	}
+
+	dw := &DumpWriter{out: out, w: bufio.NewWriter(out)}
+	dw.enc = json.NewEncoder(dw.w)
+
+	// The version comes first, so that readers can refuse a dump before
+	// reading any of it.
+	if err := dw.writeField('{', "version", DumpVersion); err != nil {
+		return nil, err
+	}
+	if _, err := dw.w.WriteString(`,"derivations":[` + "\n"); err != nil {
+		return nil, err
+	}
+
+	return dw, nil
+}
+
+// Add writes a package or package set to the dump. path includes the name of
+// the top-level package set. Add has the signature expected by StreamPackages.
+func (dw *DumpWriter) Add(path Path, drv Derivation) error {
+	v := dumpDerivationJSON{Path: path.Parts()[1:]}
+
+	switch drv := drv.(type) {
+	case Package:
+		drv.Name = ""
+		v.Type = "derivation"
+		v.Package = &drv
+		dw.packages++
+	case PackageSet:
+		v.Type = "packageSet"
+	default:
+		panic("unknown package type")
+	}
+
+	if dw.n > 0 {
+		if err := dw.w.WriteByte(','); err != nil {
+			return err
+		}
+	}
+	dw.n++
+
+	return dw.enc.Encode(v)
+}
+
+// addPackageSet adds all packages and package sets in the given set, sorted
+// by name.
+func (dw *DumpWriter) addPackageSet(path Path, set PackageSet) error {
+	for _, name := range slices.Sorted(maps.Keys(set)) {
+		if err := dw.Add(path.Push(name), set[name]); err != nil {
+			return err
+		}
+		if set, ok := set[name].(PackageSet); ok {
+			if err := dw.addPackageSet(path.Push(name), set); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Count returns the number of packages added so far.
+func (dw *DumpWriter) Count() int {
+	return dw.packages
+}
+
+// Finish writes the name and info of packages and completes the dump. The
+// packages of packages are ignored, since they are expected to have been
+// added already.
+func (dw *DumpWriter) Finish(packages TopLevelPackages) error {
+	err := dw.finish(packages)
+	if closeErr := dw.out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (dw *DumpWriter) finish(packages TopLevelPackages) error {
+	if err := dw.w.WriteByte(']'); err != nil {
+		return err
+	}
+	if err := dw.writeField(',', "channel", packages.Nixpkgs); err != nil {
+		return err
+	}
+	if err := dw.writeField(',', "flake", packages.Flake); err != nil {
+		return err
+	}
+	if err := dw.writeField(',', "info", packages.Info); err != nil {
+		return err
+	}
+	if _, err := dw.w.WriteString("}\n"); err != nil {
+		return err
+	}
+	return dw.w.Flush()
+}
+
+// writeField writes sep followed by the key and value of an object field.
+func (dw *DumpWriter) writeField(sep byte, key string, value any) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if err := dw.w.WriteByte(sep); err != nil {
+		return err
+	}
+	if _, err := dw.w.WriteString(strconv.Quote(key)); err != nil {
+		return err
+	}
+	if err := dw.w.WriteByte(':'); err != nil {
+		return err
+	}
+	_, err = dw.w.Write(b)
+	return err
+}
+
+// EncodeDump writes the packages as a dump with the given compression.
+func EncodeDump(w io.Writer, packages TopLevelPackages, compression DumpCompression) error {
+	dw, err := NewDumpWriter(w, compression)
+	if err != nil {
+		return err
+	}
+
+	root := NewPath([]string{packages.Nixpkgs}, packages.Flake)
+	if err := dw.addPackageSet(root, packages.PackageSet); err != nil {
+		dw.out.Close()
+		return err
+	}
+
+	return dw.Finish(packages)
+}
+
+// DecodeDump reads a dump written by EncodeDump. The compression is detected
+// automatically. Dumps written before dumps were versioned are also accepted,
+// but dumps with a newer schema version than DumpVersion are refused.
+func DecodeDump(r io.Reader) (TopLevelPackages, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return TopLevelPackages{}, err
+	}
+
+	var dec io.Reader = br
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return TopLevelPackages{}, errors.Wrap(err, "failed to read gzip dump")
+		}
+		defer gr.Close()
+		dec = gr
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return TopLevelPackages{}, errors.Wrap(err, "failed to read zstd dump")
+		}
+		defer zr.Close()
+		dec = zr
+	}
+
+	packages, err := decodeDump(json.NewDecoder(dec))
+	if err != nil {
+		return TopLevelPackages{}, errors.Wrap(err, "failed to decode dump")
+	}
+
+	return packages, nil
+}
+
+// decodeDump decodes the dump object key by key, so that the packages are
+// decoded straight from the stream. Versioned dumps start with the version,
+// which is checked before the packages are read. Dumps written before dumps
+// were versioned may instead be a bare package set, which is told apart by its
+// first value being an object.
+func decodeDump(dec *json.Decoder) (TopLevelPackages, error) {
+	if err := expectDelim(dec, '{'); err != nil {
+		return TopLevelPackages{}, err
+	}
+
+	var packages TopLevelPackages
+	var version int
+	var legacy bool
+
+	for i := 0; dec.More(); i++ {
+		t, err := dec.Token()
+		if err != nil {
+			return TopLevelPackages{}, err
+		}
+		key := t.(string)
+
+		if key == "derivations" && !legacy {
+			if err := decodeDumpDerivations(dec, &packages.PackageSet); err != nil {
+				return TopLevelPackages{}, errors.Wrap(err, "failed to decode derivations")
+			}
+			continue
+		}
+
+		if i == 0 || legacy {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return TopLevelPackages{}, err
+			}
+
+			legacy = legacy || bytes.HasPrefix(raw, []byte("{"))
+			if legacy {
+				drv, err := unmarshalDerivation(key, raw)
+				if err != nil {
+					return TopLevelPackages{}, errors.Wrapf(err, "failed to decode %q", key)
+				}
+				if packages.PackageSet == nil {
+					packages.PackageSet = make(PackageSet)
+				}
+				packages.PackageSet[key] = drv
+				continue
+			}
+
+			err = json.Unmarshal(raw, dumpField(&packages, &version, key))
+		} else {
+			err = dec.Decode(dumpField(&packages, &version, key))
+		}
+		if err != nil {
+			return TopLevelPackages{}, errors.Wrapf(err, "failed to decode %q", key)
+		}
+
+		if version > DumpVersion {
+			return TopLevelPackages{}, errors.Errorf(
+				"dump has schema version %d, but only up to %d is supported; upgrade nix-search",
+				version, DumpVersion)
+		}
+	}
+
+	if err := expectDelim(dec, '}'); err != nil {
+		return TopLevelPackages{}, err
+	}
+
+	return packages, nil
+}
+
+// decodeDumpDerivations decodes the "derivations" array of a dump one by one
+// into set.
+func decodeDumpDerivations(dec *json.Decoder, set *PackageSet) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+
+	if *set == nil {
+		*set = make(PackageSet)
+	}
+
+	for dec.More() {
+		var v dumpDerivationJSON
+		if err := dec.Decode(&v); err != nil {
+			return err
+		}
+		if len(v.Path) == 0 {
+			return errors.New("derivation without path")
+		}
+
+		switch v.Type {
+		case "derivation":
+			var pkg Package
+			if v.Package != nil {
+				pkg = *v.Package
+			}
+			pkg.Name = v.Path[len(v.Path)-1]
+			set.Insert(v.Path, pkg)
+		case "packageSet", "set":
+			set.Insert(v.Path, PackageSet{})
+		default:
+			return errors.Errorf("unknown package type %q", v.Type)
+		}
+	}
+
+	return expectDelim(dec, ']')
+}
+
+// dumpField returns the value to decode the given top-level key of a
+// versioned dump into. Unknown keys are skipped.
+func dumpField(packages *TopLevelPackages, version *int, key string) any {
+	switch key {
+	case "version":
+		return version
+	case "channel":
+		return &packages.Nixpkgs
+	case "flake":
+		return &packages.Flake
+	case "info":
+		return &packages.Info
+	default:
+		return new(json.RawMessage)
+	}
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return errors.Errorf("expected %q, got %v", delim, t)
+	}
+	return nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
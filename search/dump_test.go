@@ -0,0 +1,110 @@
+package search
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestDump(t *testing.T) {
+	pkgs := TopLevelPackages{
+		Nixpkgs: "nixos-24.11",
+		Info: SourceInfo{
+			Nixpkgs:   "<nixos-24.11>",
+			Revision:  "abcdef",
+			IndexedAt: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC),
+		},
+		PackageSet: PackageSet{
+			"hello": Package{Name: "hello", Version: "2.12.1"},
+			"python3Packages": PackageSet{
+				"requests": Package{Name: "requests", Version: "2.32"},
+			},
+		},
+	}
+
+	for _, compression := range []DumpCompression{DumpUncompressed, DumpGzip, DumpZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			var buf bytes.Buffer
+			assert.NoError(t, EncodeDump(&buf, pkgs, compression))
+
+			got, err := DecodeDump(&buf)
+			assert.NoError(t, err)
+			assert.Equal(t, pkgs, got)
+		})
+	}
+
+	t.Run("unversioned", func(t *testing.T) {
+		got, err := DecodeDump(strings.NewReader(`{"version":{"_type":"derivation","version":"1.0"}}`))
+		assert.NoError(t, err)
+		assert.Equal(t, TopLevelPackages{
+			PackageSet: PackageSet{
+				"version": Package{Name: "version", Version: "1.0"},
+			},
+		}, got)
+	})
+
+	t.Run("bare", func(t *testing.T) {
+		got, err := DecodeDump(strings.NewReader(`{
+			"hello": {"_type": "derivation", "version": "2.12.1"},
+			"channel": {"_type": "derivation", "version": "1.0"},
+			"python3Packages": {"_type": "set", "requests": {"_type": "derivation", "version": "2.32"}}
+		}`))
+		assert.NoError(t, err)
+		assert.Equal(t, TopLevelPackages{
+			PackageSet: PackageSet{
+				"hello":   Package{Name: "hello", Version: "2.12.1"},
+				"channel": Package{Name: "channel", Version: "1.0"},
+				"python3Packages": PackageSet{
+					"requests": Package{Name: "requests", Version: "2.32"},
+				},
+			},
+		}, got)
+	})
+
+	t.Run("newer", func(t *testing.T) {
+		_, err := DecodeDump(strings.NewReader(`{"version":999,"channel":"nixpkgs","derivations":[]}`))
+		assert.Error(t, err)
+	})
+}
+
+func TestDumpWriter(t *testing.T) {
+	root := NewPath([]string{"nixpkgs"}, false)
+
+	var buf bytes.Buffer
+	dw, err := NewDumpWriter(&buf, DumpGzip)
+	assert.NoError(t, err)
+
+	// Packages of different sets arrive interleaved while evaluating.
+	assert.NoError(t, dw.Add(root.Push("python3Packages"), PackageSet{}))
+	assert.NoError(t, dw.Add(root.Push("hello"), Package{Name: "hello", Version: "2.12.1"}))
+	assert.NoError(t, dw.Add(root.Push("haskellPackages"), PackageSet{}))
+	assert.NoError(t, dw.Add(root.Push("python3Packages", "requests"), Package{Name: "requests", Version: "2.32"}))
+	assert.Equal(t, 2, dw.Count())
+
+	info := SourceInfo{Nixpkgs: "<nixpkgs>", IndexedAt: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)}
+	assert.NoError(t, dw.Finish(TopLevelPackages{Nixpkgs: "nixpkgs", Info: info}))
+
+	got, err := DecodeDump(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, TopLevelPackages{
+		Nixpkgs: "nixpkgs",
+		Info:    info,
+		PackageSet: PackageSet{
+			"hello": Package{Name: "hello", Version: "2.12.1"},
+			"python3Packages": PackageSet{
+				"requests": Package{Name: "requests", Version: "2.32"},
+			},
+			"haskellPackages": PackageSet{},
+		},
+	}, got)
+}
+
+func TestDumpCompressionFromPath(t *testing.T) {
+	assert.Equal(t, DumpZstd, DumpCompressionFromPath("nixpkgs.json.zst"))
+	assert.Equal(t, DumpGzip, DumpCompressionFromPath("nixpkgs.json.gz"))
+	assert.Equal(t, DumpUncompressed, DumpCompressionFromPath("nixpkgs.json"))
+	assert.Equal(t, DumpUncompressed, DumpCompressionFromPath(""))
+}
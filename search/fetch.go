@@ -0,0 +1,196 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LatestRevision is the revision under which a mirror publishes its most
+// recent dump of a source.
+const LatestRevision = "latest"
+
+// ErrDumpNotFound is returned by FetchDump if the mirror has no dump for the
+// requested source and revision.
+var ErrDumpNotFound = errors.New("dump not found")
+
+// maxChecksumFileSize is the maximum size of a checksum file. It only needs to
+// hold a single line.
+const maxChecksumFileSize = 4096
+
+// FetchDump fetches a dump written by nix-dump-index from a mirror and verifies
+// its SHA-256 checksum before decoding it. The mirror is either an HTTP(S) or
+// file URL, or a local directory.
+//
+// The mirror is laid out by source name and revision. For each revision, a
+// checksum file in the format of sha256sum names the dump next to it:
+//
+//	<mirror>/<name>/<revision>.sha256
+//	<mirror>/<name>/<dump file named in the checksum file>
+//
+// If revision is empty, LatestRevision is used.
+func FetchDump(ctx context.Context, mirror, name, revision string) (TopLevelPackages, error) {
+	if revision == "" {
+		revision = LatestRevision
+	}
+
+	sumFile, err := fetchMirrorFile(ctx, mirror, name, revision+".sha256")
+	if err != nil {
+		return TopLevelPackages{}, errors.Wrap(err, "failed to fetch checksum")
+	}
+
+	wantSum, dumpName, err := parseChecksumFile(sumFile)
+	if err != nil {
+		return TopLevelPackages{}, errors.Wrap(err, "failed to parse checksum file")
+	}
+
+	// Dumps of Nixpkgs are large, so the dump is hashed while it is being
+	// downloaded into a temporary file, and only decoded from there once the
+	// checksum matches.
+	dump, err := os.CreateTemp("", "nix-search-dump-*")
+	if err != nil {
+		return TopLevelPackages{}, errors.Wrap(err, "failed to create temporary file")
+	}
+	defer os.Remove(dump.Name())
+	defer dump.Close()
+
+	gotSum, err := downloadMirrorFile(ctx, mirror, name, dumpName, dump)
+	if err != nil {
+		return TopLevelPackages{}, errors.Wrap(err, "failed to fetch dump")
+	}
+
+	if !strings.EqualFold(hex.EncodeToString(gotSum), wantSum) {
+		return TopLevelPackages{}, errors.Errorf(
+			"checksum mismatch for %s: expected %s, got %x", dumpName, wantSum, gotSum)
+	}
+
+	if _, err := dump.Seek(0, io.SeekStart); err != nil {
+		return TopLevelPackages{}, err
+	}
+
+	return DecodeDump(dump)
+}
+
+// parseChecksumFile parses the first line of a sha256sum output, returning the
+// checksum and the file name.
+func parseChecksumFile(b []byte) (sum, name string, err error) {
+	line, _, _ := bytes.Cut(b, []byte("\n"))
+
+	fields := strings.Fields(string(line))
+	if len(fields) != 2 {
+		return "", "", errors.New("expected a checksum and a file name")
+	}
+
+	sum = fields[0]
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size*2 {
+		return "", "", errors.Errorf("invalid SHA-256 checksum %q", sum)
+	}
+
+	// sha256sum marks files read in binary mode with an asterisk.
+	name = strings.TrimPrefix(fields[1], "*")
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", "", errors.Errorf("invalid dump file name %q", name)
+	}
+
+	return sum, name, nil
+}
+
+// fetchMirrorFile reads the named file of the given source from the mirror.
+// Files larger than maxChecksumFileSize are refused, since only checksum files
+// are read whole.
+func fetchMirrorFile(ctx context.Context, mirror, source, file string) ([]byte, error) {
+	r, err := openMirrorFile(ctx, mirror, source, file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(io.LimitReader(r, maxChecksumFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxChecksumFileSize {
+		return nil, errors.Errorf("file is larger than %d bytes", maxChecksumFileSize)
+	}
+	return b, nil
+}
+
+// downloadMirrorFile copies the named file of the given source from the mirror
+// into w and returns its SHA-256 checksum.
+func downloadMirrorFile(ctx context.Context, mirror, source, file string, w io.Writer) ([]byte, error) {
+	r, err := openMirrorFile(ctx, mirror, source, file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// openMirrorFile opens the named file of the given source on the mirror.
+func openMirrorFile(ctx context.Context, mirror, source, file string) (io.ReadCloser, error) {
+	// Flake references contain slashes, so escape the source name the same
+	// way that index directories are named.
+	source = url.PathEscape(source)
+
+	u, err := url.Parse(mirror)
+	if err != nil || u.Scheme == "" || u.Scheme == "file" {
+		dir := mirror
+		if err == nil && u.Scheme == "file" {
+			dir = u.Path
+		}
+
+		path := filepath.Join(dir, source, file)
+
+		f, err := os.Open(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, errors.Wrap(ErrDumpNotFound, path)
+			}
+			return nil, err
+		}
+		return f, nil
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+	default:
+		return nil, errors.Errorf("unsupported mirror URL scheme %q", u.Scheme)
+	}
+
+	u = u.JoinPath(source, file)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errors.Wrap(ErrDumpNotFound, u.String())
+	case resp.StatusCode != http.StatusOK:
+		resp.Body.Close()
+		return nil, errors.Errorf("unexpected status %s from %s", resp.Status, u)
+	}
+
+	return resp.Body, nil
+}
@@ -0,0 +1,76 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestFetchDump(t *testing.T) {
+	ctx := context.Background()
+
+	pkgs := TopLevelPackages{
+		Nixpkgs: "nixos-24.11",
+		PackageSet: PackageSet{
+			"hello": Package{Name: "hello", Version: "2.12.1"},
+		},
+	}
+
+	var dump bytes.Buffer
+	assert.NoError(t, EncodeDump(&dump, pkgs, DumpZstd))
+
+	mirror := t.TempDir()
+	sourceDir := filepath.Join(mirror, "nixos-24.11")
+	assert.NoError(t, os.Mkdir(sourceDir, 0755))
+
+	writeFile := func(name, content string) {
+		t.Helper()
+		assert.NoError(t, os.WriteFile(filepath.Join(sourceDir, name), []byte(content), 0644))
+	}
+
+	writeFile("abc123.json.zst", dump.String())
+	writeFile("abc123.sha256", fmt.Sprintf("%x  abc123.json.zst\n", sha256.Sum256(dump.Bytes())))
+	writeFile("latest.sha256", fmt.Sprintf("%x *abc123.json.zst\n", sha256.Sum256(dump.Bytes())))
+	writeFile("corrupt.sha256", fmt.Sprintf("%x  abc123.json.zst\n", sha256.Sum256(nil)))
+	writeFile("escape.sha256", fmt.Sprintf("%x  ../abc123.json.zst\n", sha256.Sum256(dump.Bytes())))
+
+	server := httptest.NewServer(http.FileServer(http.Dir(mirror)))
+	t.Cleanup(server.Close)
+
+	mirrors := map[string]string{
+		"directory": mirror,
+		"file":      "file://" + filepath.ToSlash(mirror),
+		"http":      server.URL,
+	}
+
+	for name, mirror := range mirrors {
+		t.Run(name, func(t *testing.T) {
+			for _, revision := range []string{"abc123", "latest", ""} {
+				got, err := FetchDump(ctx, mirror, "nixos-24.11", revision)
+				assert.NoError(t, err)
+				assert.Equal(t, pkgs, got)
+			}
+
+			_, err := FetchDump(ctx, mirror, "nixos-24.11", "def456")
+			assert.True(t, errors.Is(err, ErrDumpNotFound), "missing revision: %v", err)
+
+			_, err = FetchDump(ctx, mirror, "nixos-unstable", "")
+			assert.True(t, errors.Is(err, ErrDumpNotFound), "missing source: %v", err)
+
+			_, err = FetchDump(ctx, mirror, "nixos-24.11", "corrupt")
+			assert.Error(t, err)
+
+			_, err = FetchDump(ctx, mirror, "nixos-24.11", "escape")
+			assert.Error(t, err)
+		})
+	}
+}
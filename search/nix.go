@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -20,13 +22,19 @@ type packagesDump map[string]struct {
 	HasMore bool            `json:"hasMore"`
 }
 
-// dumpPackages returns a list of all packages in the given channel.
-func dumpPackages(ctx context.Context, nixpkgs string, attrs []string) (packagesDump, error) {
+// dumpPackages returns a list of all packages in the given channel. The
+// packages are evaluated for the current system, and opts.Systems are only
+// checked for which of them each package is available on.
+func dumpPackages(ctx context.Context, opts IndexPackagesOpts, attrs []string) (packagesDump, error) {
 	stdout, err := execCommandWriter(ctx,
 		"nix-instantiate", "--eval", "--json", "--strict",
 		"-E", nixExprDumpPackages,
-		"--arg", "nixpkgs", nixpkgs,
-		"--arg", "attrs", toNixArray(attrs))
+		"--arg", "nixpkgs", opts.Nixpkgs,
+		"--arg", "systems", toNixArray(opts.Systems),
+		"--arg", "config", nixpkgsConfigExpr(opts.Config),
+		"--arg", "overlays", nixpkgsOverlaysExpr(opts.Overlays),
+		"--arg", "attrs", toNixArray(attrs),
+		"--arg", "withOutPaths", strconv.FormatBool(opts.IndexBinaries))
 	if err != nil {
 		return nil, err
 	}
@@ -56,28 +64,206 @@ func toNixArray(args []string) string {
 	var b strings.Builder
 	b.WriteString("[")
 	for _, arg := range args {
-		b.WriteString(strconv.Quote(arg))
+		b.WriteString(toNixString(arg))
 		b.WriteByte(' ')
 	}
 	b.WriteString("]")
 	return b.String()
 }
 
+// toNixString quotes s as a Nix string literal.
+func toNixString(s string) string {
+	// Go escapes are a superset of what Nix needs, except that Nix would
+	// interpolate ${.
+	return strings.ReplaceAll(strconv.Quote(s), "${", `\${`)
+}
+
+// resolveNixpkgsConfigPaths makes the config file and overlay paths in opts
+// absolute, since nix-instantiate does not evaluate them relative to the
+// working directory. Overlays that do not exist are an error.
+func resolveNixpkgsConfigPaths(opts *IndexPackagesOpts) error {
+	if opts.Config != "" {
+		if _, err := os.Stat(opts.Config); err == nil {
+			path, err := filepath.Abs(opts.Config)
+			if err != nil {
+				return errors.Wrap(err, "failed to resolve config path")
+			}
+			opts.Config = path
+		}
+	}
+
+	if opts.Overlays != nil {
+		overlays := make([]string, len(opts.Overlays))
+		for i, overlay := range opts.Overlays {
+			if _, err := os.Stat(overlay); err != nil {
+				return errors.Wrap(err, "failed to find overlay")
+			}
+
+			path, err := filepath.Abs(overlay)
+			if err != nil {
+				return errors.Wrap(err, "failed to resolve overlay path")
+			}
+			overlays[i] = path
+		}
+		opts.Overlays = overlays
+	}
+
+	return nil
+}
+
+// nixpkgsConfigExpr returns the Nix expression for the given Nixpkgs config,
+// which is an absolute path to a file after resolveNixpkgsConfigPaths.
+func nixpkgsConfigExpr(config string) string {
+	switch {
+	case config == "":
+		return "null"
+	case filepath.IsAbs(config):
+		return "import " + toNixString(config)
+	default:
+		return config
+	}
+}
+
+// nixpkgsOverlaysExpr returns the Nix expression for the given overlay paths.
+func nixpkgsOverlaysExpr(overlays []string) string {
+	if overlays == nil {
+		return "null"
+	}
+	return toNixArray(overlays)
+}
+
 // ResolveNixPathFromFlake returns the flake-locked Nix store path for the given flake.
 // Using this path, one can directly do `import (path) { }` to evaluate the
 // Nixpkgs instance like using a channel.
 func ResolveNixPathFromFlake(ctx context.Context, flake string) (string, error) {
+	metadata, err := GetFlakeMetadata(ctx, flake)
+	if err != nil {
+		return "", err
+	}
+	return metadata.Path, nil
+}
+
+// FlakeMetadata is the subset of `nix flake metadata --json` that nix-search
+// uses.
+type FlakeMetadata struct {
+	// Path is the flake-locked Nix store path of the flake.
+	Path string `json:"path"`
+	// Revision is the locked Git revision of the flake, if any.
+	Revision string `json:"revision"`
+	// Locked contains the locked flake reference.
+	Locked struct {
+		NarHash      string `json:"narHash"`
+		LastModified int64  `json:"lastModified"`
+	} `json:"locked"`
+}
+
+// GetFlakeMetadata returns the metadata of the given flake.
+func GetFlakeMetadata(ctx context.Context, flake string) (FlakeMetadata, error) {
 	stdout, err := execCommand(ctx, "nix", "flake", "metadata", flake, "--json")
+	if err != nil {
+		return FlakeMetadata{}, err
+	}
+
+	var metadata FlakeMetadata
+	if err := json.Unmarshal([]byte(stdout), &metadata); err != nil {
+		return FlakeMetadata{}, errors.Wrap(err, "failed to parse flake metadata")
+	}
+
+	return metadata, nil
+}
+
+// ResolveNixpkgsPath resolves the given Nixpkgs path, which may be a lookup
+// path like <nixpkgs>, into the Nix store path that it currently points to.
+// Channels are usually symlinks into the store, so the result changes
+// whenever the channel is updated.
+func ResolveNixpkgsPath(ctx context.Context, nixpkgs string) (string, error) {
+	path := nixpkgs
+	if strings.HasPrefix(path, "<") && strings.HasSuffix(path, ">") {
+		stdout, err := execCommand(ctx, "nix-instantiate", "--find-file", path[1:len(path)-1])
+		if err != nil {
+			return "", err
+		}
+		path = strings.TrimSpace(stdout)
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to resolve nixpkgs path")
+	}
+
+	return resolved, nil
+}
+
+// IsStale reports whether the source described by info has moved since it
+// was indexed, i.e. whether the channel now points to a different store path
+// or the flake is locked to a different revision. If info does not record
+// where the source was resolved to, false is returned.
+func IsStale(ctx context.Context, info SourceInfo) (bool, error) {
+	if info.StorePath == "" {
+		return false, nil
+	}
+
+	if info.Flake {
+		metadata, err := GetFlakeMetadata(ctx, info.Nixpkgs)
+		if err != nil {
+			return false, err
+		}
+		if info.NarHash != "" {
+			return metadata.Locked.NarHash != info.NarHash, nil
+		}
+		return metadata.Path != info.StorePath, nil
+	}
+
+	storePath, err := ResolveNixpkgsPath(ctx, info.Nixpkgs)
+	if err != nil {
+		return false, err
+	}
+	return storePath != info.StorePath, nil
+}
+
+// SourceRevision returns the Git revision of the channel or flake described by
+// opts as it currently is, without indexing it. An empty string is returned
+// if the channel does not record its revision.
+func SourceRevision(ctx context.Context, opts IndexPackagesOpts) (string, error) {
+	if opts.Flake != "" {
+		metadata, err := GetFlakeMetadata(ctx, opts.Flake)
+		if err != nil {
+			return "", err
+		}
+		return metadata.Revision, nil
+	}
+
+	storePath, err := ResolveNixpkgsPath(ctx, opts.Nixpkgs)
 	if err != nil {
 		return "", err
 	}
+	return readNixpkgsRevision(storePath), nil
+}
 
-	var output struct {
-		Path string `json:"path"`
+// readNixpkgsRevision reads the Git revision that the Nixpkgs tree at the
+// given path was built from. Channel tarballs include this in a
+// .git-revision file. An empty string is returned if it is unknown.
+func readNixpkgsRevision(path string) string {
+	b, err := os.ReadFile(filepath.Join(path, ".git-revision"))
+	if err != nil {
+		return ""
 	}
-	if err := json.Unmarshal([]byte(stdout), &output); err != nil {
-		return "", errors.Wrap(err, "failed to parse flake metadata")
+	return strings.TrimSpace(string(b))
+}
+
+// currentSystem returns the system that Nix evaluates for by default, e.g.
+// "x86_64-linux".
+func currentSystem(ctx context.Context) (string, error) {
+	stdout, err := execCommand(ctx,
+		"nix-instantiate", "--eval", "--json", "-E", "builtins.currentSystem")
+	if err != nil {
+		return "", err
+	}
+
+	var system string
+	if err := json.Unmarshal([]byte(stdout), &system); err != nil {
+		return "", errors.Wrap(err, "failed to parse current system")
 	}
 
-	return output.Path, nil
+	return system, nil
 }
@@ -1,15 +1,35 @@
 {
 	nixpkgs ? <nixpkgs>,
 	system ? builtins.currentSystem,
+	# systems are the systems to check the availability of each package on.
+	# Packages are only evaluated for system, so this is much cheaper than
+	# evaluating Nixpkgs once for each system, but attributes that only exist
+	# on other systems are not listed.
+	systems ? [ system ],
+	# config is the Nixpkgs configuration, e.g. { allowUnfree = true; }. If
+	# null, Nixpkgs reads it from the usual locations.
+	config ? null,
+	# overlays is a list of paths to overlay files. If null, Nixpkgs reads
+	# them from the usual locations.
+	overlays ? null,
 	attrs ? [],
+	# withOutPaths includes the output path of each package. This is slower,
+	# since every derivation must be instantiated.
+	withOutPaths ? false,
 }:
 
 with builtins;
 
 let
-	pkgs = import nixpkgs {
-		inherit system;
-	};
+	nixpkgsArgs = { inherit system; }
+		// (if config != null then { inherit config; } else { });
+
+	pkgs = import nixpkgs (nixpkgsArgs
+		// (if overlays != null then { overlays = map import overlays; } else { }));
+
+	# basePkgs is Nixpkgs without overlays, used to tell which packages are
+	# added by an overlay.
+	basePkgs = import nixpkgs (nixpkgsArgs // { overlays = [ ]; });
 in
 
 with pkgs.lib;
@@ -17,6 +37,11 @@ with builtins;
 
 let
 	pkgs' = attrByPath attrs {} pkgs;
+	basePkgs' = attrByPath attrs {} basePkgs;
+
+	# fromOverlay is true for attributes added by an overlay. Attributes that
+	# an overlay overrides already exist in basePkgs, so they are not marked.
+	fromOverlay = attr: overlays != null && !(hasAttr basePkgs' attr);
 
 	isValid = x: (tryEval x).success;
 
@@ -47,14 +72,53 @@ let
 		hasAttr x "recurseForDerivations"	&&
 		x.recurseForDerivations == true;
 
+	hostPlatforms = map pkgs.lib.systems.elaborate systems;
+
+	# availableSystems returns the systems out of systems that the package is
+	# available on according to its meta.platforms and meta.badPlatforms.
+	availableSystems = pkg: map (platform: platform.system) (filter
+		(platform:
+			let availableOn = tryEval (meta.availableOn platform pkg);
+			in  availableOn.success && availableOn.value)
+		hostPlatforms);
+
 	licenseString = license:
 		if isString license
 		then license
 		else
-			if isAttrs license && license ? "spdxId"
-			then license.spdxId
+			if isAttrs license
+			then license.spdxId or license.shortName or null
 			else null;
 
+	# Maintainer attribute sets don't contain their own handle, so map them
+	# back using their GitHub ID, which is unique.
+	maintainerHandles = listToAttrs (
+		mapAttrsToList
+			(handle: m: nameValuePair (toString m.githubId) handle)
+			(filterAttrs (_: m: isAttrs m && m ? "githubId") pkgs.lib.maintainers)
+	);
+
+	maintainerInfo = m: {
+		handle =
+			if m ? "githubId" && maintainerHandles ? ${toString m.githubId}
+			then maintainerHandles.${toString m.githubId}
+			else m.github or null;
+		name = m.name or null;
+		github = m.github or null;
+	};
+
+	# validList returns the list attribute of x filtered by f, or null if it
+	# doesn't exist or cannot be evaluated.
+	validList = f: x: attr:
+		if hasAttr x attr && isValid (deepSeq x.${attr} x.${attr})
+		then filter f (toList x.${attr})
+		else null;
+
+	validString = x: attr:
+		if hasStringAttr x attr
+		then x.${attr}
+		else null;
+
 	filterPackageMeta = pkg:
 		# List of meta attributes to include in the output.
 		# Keep this in sync with [search.Package].
@@ -65,15 +129,15 @@ let
 			"mainProgram"
 			"broken"
 		]) pkg.meta) // rec {
-			licenses =
-				if pkg.meta ? "license"
-				then map licenseString (singleton pkg.meta.license)
+			license =
+				if pkg.meta ? "license" && isValid pkg.meta.license
+				then filter (l: l != null) (map licenseString (toList pkg.meta.license))
 				else null;
 			unfree =
 				if pkg.meta ? "license" && isValid pkg.meta.license
 				then any
 					(license: license ? "free" && !license.free)
-					(singleton pkg.meta.license)
+					(toList pkg.meta.license)
 				else false;
 			unsupportedPlatform =
 				let
@@ -81,10 +145,20 @@ let
 				in
 					!availableOn.success || !availableOn.value;
 
-			# homepages =
-			# 	if pkg.meta ? "homepage"
-			# 	then singleton pkg.meta.homepage
-			# 	else null;
+			systems = availableSystems pkg;
+
+			homepages = validList isString pkg.meta "homepage";
+			maintainers =
+				let ms = validList isAttrs pkg.meta "maintainers";
+				in  if ms != null then map maintainerInfo ms else null;
+			teams =
+				let ts = validList isAttrs pkg.meta "teams";
+				in  if ts != null then filter (t: t != null) (map (t: t.shortName or null) ts) else null;
+			platforms = validList isString pkg.meta "platforms";
+			position = validString pkg.meta "position";
+			changelog = validString pkg.meta "changelog";
+			knownVulnerabilities = validList isString pkg.meta "knownVulnerabilities";
+			outputs = validList isString pkg "outputs";
 		};
 
 	# bfs is too slow for Nix.
@@ -101,16 +175,23 @@ mapAttrs
 		if shouldRecurseInto v
 		then { hasMore = true; }
 		else { meta =
-			if hasAttr v "meta" && isValid v.meta
-			then filterPackageMeta v // (
-				if hasStringAttr v "version"
-				then { version = v.version; }
+			(
+				if hasAttr v "meta" && isValid v.meta
+				then filterPackageMeta v
 				else { }
-			)
-			else
+			) // (
 				if hasStringAttr v "version"
 				then { version = v.version; }
-				else { };
+				else { }
+			) // (
+				if withOutPaths && hasStringAttr v "outPath"
+				then { outPath = v.outPath; }
+				else { }
+			) // (
+				if fromOverlay k
+				then { overlay = true; }
+				else { }
+			);
 		}
 	)
 	(filterAttrs
@@ -0,0 +1,71 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestToNixString(t *testing.T) {
+	assert.Equal(t, `"hello"`, toNixString("hello"))
+	assert.Equal(t, `"say \"hi\""`, toNixString(`say "hi"`))
+	assert.Equal(t, `"\${HOME}/$x"`, toNixString("${HOME}/$x"))
+	assert.Equal(t, `[]`, toNixArray(nil))
+	assert.Equal(t, `["a" "b" ]`, toNixArray([]string{"a", "b"}))
+}
+
+func TestNixpkgsConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.nix")
+	overlayFile := filepath.Join(dir, "overlay.nix")
+
+	assert.NoError(t, os.WriteFile(configFile, []byte("{ allowUnfree = true; }"), 0644))
+	assert.NoError(t, os.WriteFile(overlayFile, []byte("final: prev: { }"), 0644))
+
+	tests := []struct {
+		name     string
+		opts     IndexPackagesOpts
+		config   string
+		overlays string
+	}{
+		{
+			name:     "default",
+			config:   "null",
+			overlays: "null",
+		},
+		{
+			name: "expression",
+			opts: IndexPackagesOpts{
+				Config:   "{ allowUnfree = true; }",
+				Overlays: []string{},
+			},
+			config:   "{ allowUnfree = true; }",
+			overlays: "[]",
+		},
+		{
+			name: "files",
+			opts: IndexPackagesOpts{
+				Config:   configFile,
+				Overlays: []string{overlayFile},
+			},
+			config:   "import " + toNixString(configFile),
+			overlays: toNixArray([]string{overlayFile}),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := test.opts
+			assert.NoError(t, resolveNixpkgsConfigPaths(&opts))
+			assert.Equal(t, test.config, nixpkgsConfigExpr(opts.Config))
+			assert.Equal(t, test.overlays, nixpkgsOverlaysExpr(opts.Overlays))
+		})
+	}
+
+	t.Run("missing-overlay", func(t *testing.T) {
+		opts := IndexPackagesOpts{Overlays: []string{filepath.Join(dir, "missing.nix")}}
+		assert.Error(t, resolveNixpkgsConfigPaths(&opts))
+	})
+}
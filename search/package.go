@@ -4,11 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"path"
+	"path/filepath"
 	"runtime"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/pkg/errors"
@@ -30,8 +34,49 @@ type Package struct {
 	Broken              bool     `json:"broken,omitempty"`
 	Unfree              bool     `json:"unfree,omitempty"`
 	UnsupportedPlatform bool     `json:"unsupportedPlatform,omitempty"`
+	Overlay             bool     `json:"overlay,omitempty"` // added by IndexPackagesOpts.Overlays
+
+	Homepages            []string     `json:"homepages,omitempty"`
+	Maintainers          []Maintainer `json:"maintainers,omitempty"`
+	Teams                []string     `json:"teams,omitempty"`
+	Platforms            []string     `json:"platforms,omitempty"`
+	Systems              []string     `json:"systems,omitempty"`  // available out of IndexPackagesOpts.Systems
+	Position             string       `json:"position,omitempty"` // file:line
+	Outputs              []string     `json:"outputs,omitempty"`
+	Changelog            string       `json:"changelog,omitempty"`
+	KnownVulnerabilities []string     `json:"knownVulnerabilities,omitempty"`
+
+	// OutPath is the Nix store path of the package's default output. It is
+	// only set if IndexPackagesOpts.IndexBinaries is true.
+	OutPath string `json:"outPath,omitempty"`
+	// Programs lists the executables in the package's bin directory. It is
+	// only set if IndexPackagesOpts.IndexBinaries is true and the package is
+	// present in the local Nix store.
+	Programs []string `json:"programs,omitempty"`
+}
+
+// Maintainer is a maintainer of a package.
+type Maintainer struct {
+	// Handle is the maintainer's attribute name in lib.maintainers.
+	Handle string `json:"handle,omitempty"`
+	// Name is the maintainer's full name.
+	Name string `json:"name,omitempty"`
+	// GitHub is the maintainer's GitHub username.
+	GitHub string `json:"github,omitempty"`
+}
 
-	// Homepages           []string `json:"homepages,omitempty"`
+// String implements fmt.Stringer.
+func (m Maintainer) String() string {
+	switch {
+	case m.Handle != "" && m.Name != "":
+		return m.Handle + " (" + m.Name + ")"
+	case m.Handle != "":
+		return m.Handle
+	case m.Name != "":
+		return m.Name
+	default:
+		return m.GitHub
+	}
 }
 
 // TopLevelPackages is a set of packages that are top-level packages.
@@ -42,6 +87,72 @@ type TopLevelPackages struct {
 	Nixpkgs string `json:"channel"`
 	// Flake, if true, indicates that these packages are from a flake.
 	Flake bool `json:"flake"`
+	// Info describes where and when these packages were indexed from.
+	Info SourceInfo `json:"info"`
+}
+
+// SourceInfo describes the source that a set of packages was indexed from.
+type SourceInfo struct {
+	// Nixpkgs is the Nixpkgs path or flake reference that was indexed, as
+	// given in IndexPackagesOpts.
+	Nixpkgs string `json:"nixpkgs"`
+	// Flake is true if Nixpkgs is a flake reference.
+	Flake bool `json:"flake,omitempty"`
+	// StorePath is the Nix store path that Nixpkgs resolved to.
+	StorePath string `json:"storePath,omitempty"`
+	// Revision is the Git revision of the indexed source, if known.
+	Revision string `json:"revision,omitempty"`
+	// NarHash is the locked NAR hash of the flake, if Flake is true.
+	NarHash string `json:"narHash,omitempty"`
+	// System is the system that the packages were evaluated for.
+	System string `json:"system,omitempty"`
+	// Systems are the systems that the availability of packages was checked
+	// for. See Package.Systems.
+	Systems []string `json:"systems,omitempty"`
+	// Config is the Nixpkgs config that the packages were evaluated with, as
+	// given in IndexPackagesOpts.
+	Config string `json:"config,omitempty"`
+	// Overlays are the absolute paths of the overlays that the packages were
+	// evaluated with.
+	Overlays []string `json:"overlays,omitempty"`
+	// Imported is the dump file or mirror that the packages were imported
+	// from, if they were evaluated elsewhere rather than locally.
+	Imported string `json:"imported,omitempty"`
+	// IndexedAt is the time that indexing finished.
+	IndexedAt time.Time `json:"indexedAt"`
+	// Failures lists the package sets and packages that failed to index and
+	// are therefore missing.
+	Failures []IndexFailure `json:"failures,omitempty"`
+}
+
+// IndexFailure describes a package set or package that failed to index.
+type IndexFailure struct {
+	// Attr is the dot-separated attribute path relative to the top-level
+	// package set.
+	Attr string `json:"attr"`
+	// Error is the error that indexing failed with the last time.
+	Error string `json:"error"`
+	// Stderr is the end of what Nix printed to stderr, if anything.
+	Stderr string `json:"stderr,omitempty"`
+	// Attempts is the number of times that indexing was attempted.
+	Attempts int `json:"attempts"`
+	// Set is true if a whole package set failed rather than a single
+	// package.
+	Set bool `json:"set,omitempty"`
+}
+
+// IncompleteIndexError is returned by IndexPackages in strict mode if any
+// package set or package failed to index.
+type IncompleteIndexError struct {
+	Failures []IndexFailure
+}
+
+func (err *IncompleteIndexError) Error() string {
+	attrs := make([]string, len(err.Failures))
+	for i, failure := range err.Failures {
+		attrs[i] = failure.Attr
+	}
+	return "failed to index " + strings.Join(attrs, ", ")
 }
 
 // Walk walks the package set, calling f on each derivation. If f returns
@@ -50,6 +161,56 @@ func (s TopLevelPackages) Walk(f func(Path, Package) bool) {
 	s.PackageSet.Walk(NewPath([]string{s.Nixpkgs}, s.Flake), f)
 }
 
+// topLevelPackagesJSON is the JSON representation of TopLevelPackages.
+type topLevelPackagesJSON struct {
+	Nixpkgs  string     `json:"channel"`
+	Flake    bool       `json:"flake"`
+	Info     SourceInfo `json:"info"`
+	Packages PackageSet `json:"packages"`
+}
+
+// MarshalJSON implements json.Marshaler. It has to be defined on
+// TopLevelPackages itself, since the promoted MarshalJSON of PackageSet would
+// drop all other fields.
+func (s TopLevelPackages) MarshalJSON() ([]byte, error) {
+	return json.Marshal(topLevelPackagesJSON{
+		Nixpkgs:  s.Nixpkgs,
+		Flake:    s.Flake,
+		Info:     s.Info,
+		Packages: s.PackageSet,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. For compatibility with older
+// dumps, a bare package set is also accepted, in which case only the packages
+// are set.
+func (s *TopLevelPackages) UnmarshalJSON(b []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+
+	_, hasChannel := fields["channel"]
+	_, hasPackages := fields["packages"]
+	if !hasChannel || !hasPackages {
+		*s = TopLevelPackages{}
+		return json.Unmarshal(b, &s.PackageSet)
+	}
+
+	var v topLevelPackagesJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	*s = TopLevelPackages{
+		PackageSet: v.Packages,
+		Nixpkgs:    v.Nixpkgs,
+		Flake:      v.Flake,
+		Info:       v.Info,
+	}
+	return nil
+}
+
 // PackageSet is a package that is a package set.
 type PackageSet map[string]Derivation
 
@@ -97,11 +258,29 @@ func (s PackageSet) Count() int {
 	return count
 }
 
+// CountSets returns the number of package sets nested in this set, excluding
+// itself.
+func (s PackageSet) CountSets() int {
+	var count int
+	for _, v := range s {
+		if set, ok := v.(PackageSet); ok {
+			count += 1 + set.CountSets()
+		}
+	}
+	return count
+}
+
 // MarshalJSON implements json.Marshaler.
 func (s PackageSet) MarshalJSON() ([]byte, error) {
-	// Marshal this set in a special way: we want to marshal it as an object
-	// with an extra type field, so that we can distinguish between a package
-	// set and a derivation.
+	return json.Marshal(s.jsonObject())
+}
+
+// jsonObject returns the set as an object with an extra type field in each
+// value, so that we can distinguish between a package set and a derivation.
+// Nested sets carry their type field alongside their packages, since
+// embedding a PackageSet in a struct would promote its MarshalJSON and drop
+// the type field.
+func (s PackageSet) jsonObject() map[string]any {
 	m := make(map[string]any, len(s))
 	for k, v := range s {
 		switch v := v.(type) {
@@ -115,58 +294,93 @@ func (s PackageSet) MarshalJSON() ([]byte, error) {
 				Package: v,
 			}
 		case PackageSet:
-			m[k] = struct {
-				Type string `json:"_type"`
-				PackageSet
-			}{
-				Type:       "packageSet",
-				PackageSet: v,
-			}
+			set := v.jsonObject()
+			set["_type"] = "packageSet"
+			m[k] = set
 		default:
 			panic("unknown package type")
 		}
 	}
-
-	return json.Marshal(m)
+	return m
 }
 
 // UnmarshalJSON implements json.Unmarshaler.
-func (s PackageSet) UnmarshalJSON(b []byte) error {
+func (s *PackageSet) UnmarshalJSON(b []byte) error {
 	raws := make(map[string]json.RawMessage)
 	if err := json.Unmarshal(b, &raws); err != nil {
 		return err
 	}
 
+	if *s == nil {
+		*s = make(PackageSet, len(raws))
+	}
+
 	for k, v := range raws {
-		var t struct {
-			Type string `json:"_type"`
+		if k == "_type" {
+			continue
 		}
-		if err := json.Unmarshal(v, &t); err != nil {
+
+		drv, err := unmarshalDerivation(k, v)
+		if err != nil {
 			return err
 		}
+		(*s)[k] = drv
+	}
 
-		switch t.Type {
-		case "derivation":
-			var drv Package
-			if err := json.Unmarshal(v, &drv); err != nil {
-				return err
-			}
-			drv.Name = k
-			s[k] = drv
+	return nil
+}
 
-		case "set":
-			var set PackageSet
-			if err := json.Unmarshal(v, &set); err != nil {
-				return err
-			}
-			s[k] = set
+// unmarshalDerivation unmarshals the JSON of the package or package set with
+// the given name.
+func unmarshalDerivation(name string, b []byte) (Derivation, error) {
+	var t struct {
+		Type string `json:"_type"`
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
 
-		default:
-			return fmt.Errorf("unknown package type %q", t)
+	switch t.Type {
+	case "derivation":
+		var drv Package
+		if err := json.Unmarshal(b, &drv); err != nil {
+			return nil, err
 		}
+		drv.Name = name
+		return drv, nil
+
+	case "packageSet", "set":
+		var set PackageSet
+		if err := json.Unmarshal(b, &set); err != nil {
+			return nil, err
+		}
+		return set, nil
+
+	default:
+		return nil, fmt.Errorf("unknown package type %q", t.Type)
 	}
+}
 
-	return nil
+// Insert inserts the package or package set at the given path relative to
+// this set, creating package sets along the way as needed. Inserting a package
+// set where one already exists keeps the existing one.
+func (s PackageSet) Insert(parts []string, drv Derivation) {
+	for _, name := range parts[:len(parts)-1] {
+		set, ok := s[name].(PackageSet)
+		if !ok {
+			set = make(PackageSet)
+			s[name] = set
+		}
+		s = set
+	}
+
+	name := parts[len(parts)-1]
+	if _, ok := drv.(PackageSet); ok {
+		if _, ok := s[name].(PackageSet); ok {
+			return
+		}
+	}
+	s[name] = drv
 }
 
 func (Package) isDerivation()    {}
@@ -181,17 +395,78 @@ type IndexPackagesOpts struct {
 	Flake string
 	// Parallelism is the number of parallel workers to use.
 	Parallelism int
+	// Systems are the systems to check the availability of packages on, e.g.
+	// "x86_64-linux" and "aarch64-darwin". Packages are still only evaluated
+	// for the current system, so attributes that Nixpkgs only defines on other
+	// systems are missing. If empty, only the current system is used.
+	Systems []string
+	// Config is the Nixpkgs config to evaluate packages with. It is either a
+	// Nix expression, e.g. "{ allowUnfree = true; }", or the path of a file
+	// containing one, such as ~/.config/nixpkgs/config.nix. If empty, Nixpkgs
+	// looks for the config in the usual locations.
+	Config string
+	// Overlays are the paths of overlay files to apply to Nixpkgs. Packages
+	// added by them are marked with Package.Overlay. If nil, Nixpkgs looks for
+	// overlays in the usual locations.
+	Overlays []string
+	// IndexBinaries, if true, also indexes the executables of packages that
+	// are already present in the local Nix store. This is slower, since
+	// every package's output path has to be evaluated.
+	IndexBinaries bool
+	// CheckpointDir, if non-empty, is the directory to persist progress in
+	// while indexing, so that an interrupted run can be resumed. Checkpoints
+	// are keyed by the Nix store path being indexed and are removed once
+	// indexing succeeds.
+	CheckpointDir string
+	// Resume, if true, resumes from the checkpoint in CheckpointDir left
+	// behind by an interrupted run, only indexing what it had not finished.
+	// Otherwise, indexing starts over.
+	Resume bool
+	// Retries is the number of times to retry a package set that failed to
+	// evaluate, e.g. because nix-instantiate ran out of memory.
+	Retries int
+	// RetryBackoff is the delay before the first retry. It doubles with each
+	// following retry.
+	RetryBackoff time.Duration
+	// Strict, if true, fails indexing with an IncompleteIndexError if any
+	// package set or package failed to index, instead of leaving it out.
+	Strict bool
 }
 
 // DefaultIndexPackageOpts are the default options for IndexPackages.
 var DefaultIndexPackageOpts = IndexPackagesOpts{
-	Nixpkgs:     "<nixpkgs>",
-	Flake:       "",
-	Parallelism: runtime.GOMAXPROCS(-1),
+	Nixpkgs:      "<nixpkgs>",
+	Flake:        "",
+	Parallelism:  runtime.GOMAXPROCS(-1),
+	Retries:      2,
+	RetryBackoff: time.Second,
 }
 
 // IndexPackages indexes all packages in the given channel.
 func IndexPackages(ctx context.Context, opts IndexPackagesOpts) (TopLevelPackages, error) {
+	packages := PackageSet{}
+
+	top, err := StreamPackages(ctx, opts, func(path Path, drv Derivation) error {
+		packages.Insert(path.Parts()[1:], drv)
+		return nil
+	})
+	if err != nil {
+		return TopLevelPackages{}, err
+	}
+
+	top.PackageSet = packages
+	return top, nil
+}
+
+// StreamPackages indexes all packages in the given channel like IndexPackages,
+// but instead of collecting them, it calls add with each package and each
+// package set as soon as they are evaluated. Package sets are passed as empty
+// sets before any of their packages. add is never called concurrently, and if
+// it returns an error, indexing stops with that error.
+//
+// The returned TopLevelPackages describes what was indexed, but contains no
+// packages.
+func StreamPackages(ctx context.Context, opts IndexPackagesOpts, add func(Path, Derivation) error) (TopLevelPackages, error) {
 	ctx = hclog.WithContext(ctx,
 		hclog.FromContext(ctx).Named("search.IndexPackages"))
 
@@ -201,30 +476,117 @@ func IndexPackages(ctx context.Context, opts IndexPackagesOpts) (TopLevelPackage
 		"nixpkgs", opts.Nixpkgs,
 		"parallelism", opts.Parallelism)
 
+	name := SourceName(opts)
+
+	info := SourceInfo{
+		Nixpkgs: opts.Nixpkgs,
+		Flake:   opts.Flake != "",
+	}
+
 	if opts.Flake != "" {
-		path, err := ResolveNixPathFromFlake(ctx, opts.Flake)
+		metadata, err := GetFlakeMetadata(ctx, opts.Flake)
 		if err != nil {
 			return TopLevelPackages{}, errors.Wrap(err, "failed to resolve flake")
 		}
-		opts.Nixpkgs = path
+		opts.Nixpkgs = metadata.Path
+
+		info.Nixpkgs = opts.Flake
+		info.StorePath = metadata.Path
+		info.Revision = metadata.Revision
+		info.NarHash = metadata.Locked.NarHash
+	} else {
+		storePath, err := ResolveNixpkgsPath(ctx, opts.Nixpkgs)
+		if err != nil {
+			logger.Warn("cannot resolve nixpkgs path", "nixpkgs", opts.Nixpkgs, "error", err)
+		} else {
+			info.StorePath = storePath
+			info.Revision = readNixpkgsRevision(storePath)
+		}
 	}
 
-	pi := newPackageIndexer(opts)
+	system, err := currentSystem(ctx)
+	if err != nil {
+		return TopLevelPackages{}, errors.Wrap(err, "failed to get current system")
+	}
+	if len(opts.Systems) == 0 {
+		opts.Systems = []string{system}
+	}
+	for _, s := range opts.Systems {
+		// Only availability is checked for other systems, so the attributes
+		// that Nixpkgs only defines there are never seen.
+		if s != system {
+			logger.Warn(
+				"packages only defined for another system are missing, since packages are evaluated for the current system",
+				"system", s,
+				"current", system)
+		}
+	}
+	info.System = system
+	info.Systems = opts.Systems
 
-	name := opts.Nixpkgs
-	if opts.Flake != "" {
-		name = opts.Flake
-	} else if strings.HasPrefix(name, "<") && strings.HasSuffix(name, ">") {
-		name = name[1 : len(name)-1]
-	} else {
-		name = path.Base(name)
+	if err := resolveNixpkgsConfigPaths(&opts); err != nil {
+		return TopLevelPackages{}, err
+	}
+	info.Config = opts.Config
+	info.Overlays = opts.Overlays
+
+	pi := newPackageIndexer(opts, NewPath([]string{name}, opts.Flake != ""), add)
+
+	if opts.CheckpointDir != "" {
+		if info.StorePath == "" {
+			logger.Warn("cannot checkpoint without knowing the nixpkgs store path")
+		} else {
+			var err error
+			path := checkpointPath(opts.CheckpointDir, info.StorePath, opts)
+			pi.checkpoint, err = openCheckpoint(path, opts.Resume)
+			if err != nil {
+				logger.Warn("cannot open checkpoint, continuing without it", "path", path, "error", err)
+			} else if n := len(pi.checkpoint.done); n > 0 {
+				logger.Info("resuming from checkpoint", "path", path, "jobs", n)
+			}
+		}
 	}
 
+	err = pi.start(ctx)
+	if err == nil && opts.Strict && len(pi.failures) > 0 {
+		err = &IncompleteIndexError{pi.failures}
+	}
+
+	if err != nil {
+		if pi.checkpoint != nil {
+			pi.checkpoint.Close()
+			logger.Info("progress was saved, resume to continue indexing", "checkpoint", pi.checkpoint.path)
+		}
+		return TopLevelPackages{}, err
+	}
+
+	if pi.checkpoint != nil {
+		if err := pi.checkpoint.Remove(); err != nil {
+			logger.Warn("cannot remove checkpoint", "path", pi.checkpoint.path, "error", err)
+		}
+	}
+
+	info.IndexedAt = time.Now()
+	info.Failures = pi.failures
+
 	return TopLevelPackages{
-		PackageSet: pi.packages,
-		Nixpkgs:    name,
-		Flake:      opts.Flake != "",
-	}, pi.start(ctx)
+		Nixpkgs: name,
+		Flake:   opts.Flake != "",
+		Info:    info,
+	}, nil
+}
+
+// SourceName returns the name of the top-level package set that IndexPackages
+// would produce for the given options.
+func SourceName(opts IndexPackagesOpts) string {
+	switch {
+	case opts.Flake != "":
+		return opts.Flake
+	case strings.HasPrefix(opts.Nixpkgs, "<") && strings.HasSuffix(opts.Nixpkgs, ">"):
+		return opts.Nixpkgs[1 : len(opts.Nixpkgs)-1]
+	default:
+		return path.Base(opts.Nixpkgs)
+	}
 }
 
 // Path is a path to a package. It always starts with the channel name.
@@ -305,14 +667,16 @@ func (p Path) Clone() Path {
 }
 
 type packageIndexJob struct {
-	attrs  []string
-	parent PackageSet
+	attrs []string
 }
 
 type packageIndexResult struct {
 	packageIndexJob
-	error error
-	jobs  []packageIndexJob // more jobs
+	error    error
+	attempts int
+	packages map[string]Package
+	sets     []string       // attributes of package sets to index next
+	failures []IndexFailure // packages in the set that failed to decode
 }
 
 func errorPackageIndexResult(job packageIndexJob, err error) packageIndexResult {
@@ -323,18 +687,22 @@ func errorPackageIndexResult(job packageIndexJob, err error) packageIndexResult
 }
 
 type packageIndexer struct {
-	opts     IndexPackagesOpts
-	packages PackageSet
+	opts       IndexPackagesOpts
+	root       Path
+	add        func(Path, Derivation) error
+	failures   []IndexFailure
+	checkpoint *checkpoint // nil if not checkpointing
 }
 
-func newPackageIndexer(opts IndexPackagesOpts) packageIndexer {
-	return packageIndexer{
-		packages: PackageSet{},
-		opts:     opts,
+func newPackageIndexer(opts IndexPackagesOpts, root Path, add func(Path, Derivation) error) *packageIndexer {
+	return &packageIndexer{
+		opts: opts,
+		root: root,
+		add:  add,
 	}
 }
 
-func (pi packageIndexer) start(ctx context.Context) error {
+func (pi *packageIndexer) start(ctx context.Context) error {
 	logger := hclog.FromContext(ctx)
 	defer logger.Debug("done indexing packages")
 
@@ -343,8 +711,7 @@ func (pi packageIndexer) start(ctx context.Context) error {
 
 	jobs := make([]packageIndexJob, 1, 1024)
 	jobs[0] = packageIndexJob{
-		attrs:  []string{},
-		parent: pi.packages,
+		attrs: []string{},
 	}
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -367,6 +734,22 @@ func (pi packageIndexer) start(ctx context.Context) error {
 	var ongoing int
 
 	for len(jobs) > 0 || ongoing > 0 {
+		// Jobs finished before an interruption are replayed from the
+		// checkpoint instead of being evaluated again.
+		if len(jobs) > 0 && pi.checkpoint != nil {
+			if rec, ok := pi.checkpoint.lookup(jobs[0].attrs); ok {
+				job := jobs[0]
+				jobs = jobs[1:]
+				newJobs, err := pi.apply(job, rec.Packages, rec.Sets)
+				if err != nil {
+					return err
+				}
+				jobs = append(jobs, newJobs...)
+				pi.failures = append(pi.failures, rec.Failures...)
+				continue
+			}
+		}
+
 		var job packageIndexJob
 		var jobCh2 chan<- packageIndexJob
 
@@ -388,85 +771,192 @@ func (pi packageIndexer) start(ctx context.Context) error {
 		case result := <-outCh:
 			ongoing--
 
-			level := hclog.Debug
-			msg := "finished job"
 			if result.error != nil {
 				if len(result.attrs) == 0 {
 					return result.error
 				}
-				level = hclog.Warn
-				msg = "failed job"
+
+				logger.Warn("failed job",
+					"attrs", result.attrs,
+					"error", result.error,
+					"attempts", result.attempts)
+
+				failure := IndexFailure{
+					Attr:     strings.Join(result.attrs, "."),
+					Error:    result.error.Error(),
+					Attempts: result.attempts,
+					Set:      true,
+				}
+
+				var cmdErr *CommandError
+				if errors.As(result.error, &cmdErr) {
+					failure.Stderr = cmdErr.Stderr()
+				}
+
+				pi.failures = append(pi.failures, failure)
+				continue
 			}
 
-			logger.Log(level, msg,
+			logger.Debug("finished job",
 				"attrs", result.attrs,
-				"error", result.error,
-				"jobs", len(result.jobs))
+				"jobs", len(result.sets),
+				"attempts", result.attempts)
+
+			for _, failure := range result.failures {
+				logger.Warn("failed package", "attr", failure.Attr, "error", failure.Error)
+			}
 
-			jobs = append(jobs, result.jobs...)
+			newJobs, err := pi.apply(result.packageIndexJob, result.packages, result.sets)
+			if err != nil {
+				return err
+			}
+			jobs = append(jobs, newJobs...)
+			pi.failures = append(pi.failures, result.failures...)
+
+			if pi.checkpoint != nil {
+				rec := checkpointRecord{
+					Attrs:    result.attrs,
+					Packages: result.packages,
+					Sets:     result.sets,
+					Failures: result.failures,
+				}
+				if err := pi.checkpoint.record(rec); err != nil {
+					logger.Warn("cannot write checkpoint, continuing without it", "error", err)
+					pi.checkpoint.Close()
+					pi.checkpoint = nil
+				}
+			}
 		}
 	}
 
 	return nil
 }
 
-func (pi packageIndexer) worker(ctx context.Context, jobCh <-chan packageIndexJob, outCh chan<- packageIndexResult) {
-	emit := func(out packageIndexResult) {
-		select {
-		case <-ctx.Done():
-			log := hclog.FromContext(ctx)
-			log.Warn("worker exiting early due to context cancellation")
-			return
-		case outCh <- out:
-			// ok
+// apply passes the package sets and packages found by a finished job on to
+// pi.add. Jobs to index the package sets are returned.
+func (pi *packageIndexer) apply(job packageIndexJob, packages map[string]Package, sets []string) ([]packageIndexJob, error) {
+	jobs := make([]packageIndexJob, 0, len(sets))
+	for _, attr := range sets {
+		attrs := appendCopy(job.attrs, attr)
+		if err := pi.add(pi.root.Push(attrs...), PackageSet{}); err != nil {
+			return nil, err
 		}
+		jobs = append(jobs, packageIndexJob{attrs: attrs})
 	}
 
+	for attr, pkg := range packages {
+		if err := pi.add(pi.root.Push(appendCopy(job.attrs, attr)...), pkg); err != nil {
+			return nil, err
+		}
+	}
+
+	return jobs, nil
+}
+
+func (pi *packageIndexer) worker(ctx context.Context, jobCh <-chan packageIndexJob, outCh chan<- packageIndexResult) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
 
 		case job := <-jobCh:
-			log := hclog.FromContext(ctx)
-			log.Debug("worker: indexing", "attrs", strings.Join(job.attrs, "."))
-
-			out, err := dumpPackages(ctx, pi.opts.Nixpkgs, job.attrs)
-			if err != nil {
-				emit(errorPackageIndexResult(job, err))
-				continue
+			result := pi.index(ctx, job)
+
+			select {
+			case <-ctx.Done():
+				log := hclog.FromContext(ctx)
+				log.Warn("worker exiting early due to context cancellation")
+				return
+			case outCh <- result:
+				// ok
 			}
+		}
+	}
+}
 
-			var jobs []packageIndexJob
+// index runs the job, retrying it with exponential backoff if it fails.
+func (pi *packageIndexer) index(ctx context.Context, job packageIndexJob) packageIndexResult {
+	log := hclog.FromContext(ctx)
+	backoff := pi.opts.RetryBackoff
 
-			for attr, pkg := range out {
-				if pkg.HasMore {
-					newSet := PackageSet{}
-					job.parent[attr] = newSet
+	for attempt := 1; ; attempt++ {
+		log.Debug("worker: indexing", "attrs", strings.Join(job.attrs, "."), "attempt", attempt)
 
-					jobs = append(jobs, packageIndexJob{
-						attrs:  appendCopy(job.attrs, attr),
-						parent: newSet,
-					})
-					continue
-				}
+		result := pi.indexOnce(ctx, job)
+		result.attempts = attempt
 
-				ppkg := Package{Name: attr}
-				if err := json.Unmarshal(pkg.Meta, &ppkg); err != nil {
-					err = fmt.Errorf("cannot unmarshal package %q: %w", attr, err)
-					emit(errorPackageIndexResult(job, err))
-					continue
-				}
+		if result.error == nil || attempt > pi.opts.Retries || ctx.Err() != nil {
+			return result
+		}
 
-				job.parent[attr] = ppkg
-			}
+		log.Debug("worker: retrying failed job",
+			"attrs", strings.Join(job.attrs, "."),
+			"error", result.error,
+			"backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return result
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+func (pi *packageIndexer) indexOnce(ctx context.Context, job packageIndexJob) packageIndexResult {
+	out, err := dumpPackages(ctx, pi.opts, job.attrs)
+	if err != nil {
+		return errorPackageIndexResult(job, err)
+	}
 
-			emit(packageIndexResult{
-				packageIndexJob: job,
-				jobs:            jobs,
+	result := packageIndexResult{
+		packageIndexJob: job,
+		packages:        make(map[string]Package, len(out)),
+	}
+
+	for attr, pkg := range out {
+		if pkg.HasMore {
+			result.sets = append(result.sets, attr)
+			continue
+		}
+
+		// A package that cannot be decoded is left out on its own, since
+		// evaluating the set again would not change it.
+		ppkg := Package{Name: attr}
+		if err := json.Unmarshal(pkg.Meta, &ppkg); err != nil {
+			result.failures = append(result.failures, IndexFailure{
+				Attr:     strings.Join(appendCopy(job.attrs, attr), "."),
+				Error:    fmt.Sprintf("cannot unmarshal package: %v", err),
+				Attempts: 1,
 			})
+			continue
 		}
+
+		if ppkg.OutPath != "" {
+			ppkg.Programs = listPrograms(ppkg.OutPath)
+		}
+
+		result.packages[attr] = ppkg
 	}
+
+	return result
+}
+
+// listPrograms lists the executables in the bin directory of the given store
+// path. Nil is returned if the path is not in the local Nix store.
+func listPrograms(outPath string) []string {
+	entries, err := os.ReadDir(filepath.Join(outPath, "bin"))
+	if err != nil {
+		return nil
+	}
+
+	programs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			programs = append(programs, entry.Name())
+		}
+	}
+	return programs
 }
 
 func appendCopy(dst []string, src ...string) []string {
@@ -479,3 +969,64 @@ func max(i, j int) int {
 	}
 	return j
 }
+
+// CompareVersions compares two package versions the same way that Nix's
+// builtins.compareVersions does. It returns -1 if a is older than b, 0 if they
+// are equal and 1 if a is newer than b.
+func CompareVersions(a, b string) int {
+	for a != "" || b != "" {
+		var ca, cb string
+		ca, a = nextVersionComponent(a)
+		cb, b = nextVersionComponent(b)
+
+		if versionComponentLess(ca, cb) {
+			return -1
+		}
+		if versionComponentLess(cb, ca) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// nextVersionComponent returns the next component of a version, which is
+// either a run of digits or a run of other characters, skipping the '.' and
+// '-' separators in between.
+func nextVersionComponent(v string) (component, rest string) {
+	v = strings.TrimLeft(v, ".-")
+	if v == "" {
+		return "", ""
+	}
+
+	isDigit := func(c byte) bool { return '0' <= c && c <= '9' }
+	digits := isDigit(v[0])
+
+	n := 1
+	for n < len(v) && v[n] != '.' && v[n] != '-' && isDigit(v[n]) == digits {
+		n++
+	}
+	return v[:n], v[n:]
+}
+
+func versionComponentLess(a, b string) bool {
+	na, aErr := strconv.ParseUint(a, 10, 64)
+	nb, bErr := strconv.ParseUint(b, 10, 64)
+	aNum, bNum := aErr == nil, bErr == nil
+
+	switch {
+	case aNum && bNum:
+		return na < nb
+	case a == "" && bNum:
+		return true
+	case a == "pre" && b != "pre":
+		return true
+	case b == "pre":
+		return false
+	case aNum:
+		return false
+	case bNum:
+		return true
+	default:
+		return a < b
+	}
+}
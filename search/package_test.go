@@ -0,0 +1,145 @@
+package search
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.3", -1},
+		{"2.1", "2.3", -1},
+		{"2.3", "2.3", 0},
+		{"2.5", "2.3", 1},
+		{"3.1", "2.3", 1},
+		{"2.3.1", "2.3", 1},
+		{"2.3.1", "2.3a", 1},
+		{"2.3pre1", "2.3", -1},
+		{"2.3pre3", "2.3pre12", -1},
+		{"2.3a", "2.3c", -1},
+		{"2.3pre1", "2.3c", -1},
+		{"2.3pre1", "2.3q", -1},
+		{"1.10", "1.9", 1},
+		{"0-unstable-2024-05-01", "0-unstable-2024-06-01", -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a+"_"+test.b, func(t *testing.T) {
+			assert.Equal(t, test.want, CompareVersions(test.a, test.b))
+			assert.Equal(t, -test.want, CompareVersions(test.b, test.a))
+		})
+	}
+}
+
+func TestPackageSetJSON(t *testing.T) {
+	set := PackageSet{
+		"hello": Package{Name: "hello", Version: "2.12.1"},
+		"python3Packages": PackageSet{
+			"requests": Package{Name: "requests", Version: "2.32"},
+		},
+	}
+
+	b, err := json.Marshal(set)
+	assert.NoError(t, err)
+
+	var got PackageSet
+	assert.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, set, got)
+}
+
+func TestPackageSetInsert(t *testing.T) {
+	set := PackageSet{}
+	set.Insert([]string{"hello"}, Package{Name: "hello", Version: "2.12.1"})
+	set.Insert([]string{"python3Packages", "requests"}, Package{Name: "requests", Version: "2.32"})
+	set.Insert([]string{"python3Packages"}, PackageSet{})
+	set.Insert([]string{"perlPackages"}, PackageSet{})
+
+	assert.Equal(t, PackageSet{
+		"hello": Package{Name: "hello", Version: "2.12.1"},
+		"python3Packages": PackageSet{
+			"requests": Package{Name: "requests", Version: "2.32"},
+		},
+		"perlPackages": PackageSet{},
+	}, set)
+}
+
+func TestTopLevelPackagesJSON(t *testing.T) {
+	pkgs := TopLevelPackages{
+		Nixpkgs: "nixos-24.11",
+		Info: SourceInfo{
+			Nixpkgs:   "<nixos-24.11>",
+			IndexedAt: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC),
+		},
+		PackageSet: PackageSet{
+			"hello": Package{Name: "hello", Version: "2.12.1"},
+		},
+	}
+
+	t.Run("roundtrip", func(t *testing.T) {
+		b, err := json.Marshal(pkgs)
+		assert.NoError(t, err)
+
+		var got TopLevelPackages
+		assert.NoError(t, json.Unmarshal(b, &got))
+		assert.Equal(t, pkgs, got)
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		b, err := json.Marshal(pkgs.PackageSet)
+		assert.NoError(t, err)
+
+		var got TopLevelPackages
+		assert.NoError(t, json.Unmarshal(b, &got))
+		assert.Equal(t, TopLevelPackages{PackageSet: pkgs.PackageSet}, got)
+	})
+}
+
+func TestDiffPackages(t *testing.T) {
+	from := TopLevelPackages{
+		Nixpkgs: "nixos-24.05",
+		PackageSet: PackageSet{
+			"hello":   Package{Name: "hello", Version: "2.12.1"},
+			"firefox": Package{Name: "firefox", Version: "130", Licenses: []string{"MPL-2.0"}},
+			"python3Packages": PackageSet{
+				"requests": Package{Name: "requests", Version: "2.32"},
+			},
+		},
+	}
+
+	to := TopLevelPackages{
+		Nixpkgs: "nixos-24.11",
+		PackageSet: PackageSet{
+			"hello":   Package{Name: "hello", Version: "2.12.1"},
+			"firefox": Package{Name: "firefox", Version: "131", Licenses: []string{"MPL-2.0"}, Broken: true},
+			"ripgrep": Package{Name: "ripgrep", Version: "14.1"},
+		},
+	}
+
+	type change struct {
+		path   string
+		change PackageChange
+		fields []string
+	}
+
+	var got []change
+	for _, diff := range DiffPackages(from, to) {
+		c := change{path: diff.Path, change: diff.Change}
+		for _, field := range diff.Fields {
+			c.fields = append(c.fields, field.Field)
+		}
+		got = append(got, c)
+	}
+
+	assert.Equal(t, []change{
+		{"firefox", PackageChanged, []string{"version", "broken"}},
+		{"python3Packages.requests", PackageRemoved, nil},
+		{"ripgrep", PackageAdded, nil},
+	}, got)
+}
@@ -0,0 +1,481 @@
+package search
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// QueryField is a field that a query term can be restricted to.
+type QueryField string
+
+const (
+	// AnyField matches a term against the path, name and description of a
+	// package. It is the field of all unqualified terms.
+	AnyField         QueryField = ""
+	NameField        QueryField = "name"
+	PathField        QueryField = "path"
+	DescriptionField QueryField = "description"
+	LicenseField     QueryField = "license"
+	ProgramField     QueryField = "program"
+	VersionField     QueryField = "version"
+	HomepageField    QueryField = "homepage"
+	TeamField        QueryField = "team"
+	// MaintainerField matches the handle or GitHub username of a maintainer
+	// exactly, ignoring case.
+	MaintainerField QueryField = "maintainer"
+	// SystemField matches packages that are available on the given system,
+	// e.g. "aarch64-linux".
+	SystemField QueryField = "system"
+
+	// BrokenField, UnfreeField, UnsupportedField and OverlayField match
+	// boolean package attributes. Their terms must be either "true" or "false".
+	BrokenField      QueryField = "broken"
+	UnfreeField      QueryField = "unfree"
+	UnsupportedField QueryField = "unsupported"
+	OverlayField     QueryField = "overlay"
+)
+
+// QueryFields is the list of all fields that can be used in a query.
+var QueryFields = []QueryField{
+	NameField,
+	PathField,
+	DescriptionField,
+	LicenseField,
+	ProgramField,
+	VersionField,
+	HomepageField,
+	TeamField,
+	MaintainerField,
+	SystemField,
+	BrokenField,
+	UnfreeField,
+	UnsupportedField,
+	OverlayField,
+}
+
+func isQueryField(name string) bool {
+	return slices.Contains(QueryFields, QueryField(name))
+}
+
+// IsBool returns true if the field is a boolean field.
+func (f QueryField) IsBool() bool {
+	return f == BrokenField || f == UnfreeField || f == UnsupportedField || f == OverlayField
+}
+
+// Query is a parsed search query. It is one of TermQuery, AndQuery, OrQuery
+// or NotQuery.
+type Query interface {
+	fmt.Stringer
+	isQuery()
+}
+
+// TermQuery matches packages containing a term in the given field.
+type TermQuery struct {
+	// Field is the field to match against. If empty, the term is matched
+	// against any field.
+	Field QueryField
+	// Term is the term to match.
+	Term string
+	// Phrase is true if the term was quoted and should be matched as a whole
+	// phrase.
+	Phrase bool
+}
+
+// AndQuery matches packages that match all of its queries.
+type AndQuery []Query
+
+// OrQuery matches packages that match any of its queries.
+type OrQuery []Query
+
+// NotQuery matches packages that do not match its query.
+type NotQuery struct {
+	Query Query
+}
+
+func (TermQuery) isQuery() {}
+func (AndQuery) isQuery()  {}
+func (OrQuery) isQuery()   {}
+func (NotQuery) isQuery()  {}
+
+// String implements fmt.Stringer.
+func (q TermQuery) String() string {
+	term := q.Term
+	if q.Phrase {
+		term = strconv.Quote(term)
+	}
+	if q.Field != AnyField {
+		return string(q.Field) + ":" + term
+	}
+	return term
+}
+
+// String implements fmt.Stringer.
+func (q AndQuery) String() string { return joinQueries(q, " AND ") }
+
+// String implements fmt.Stringer.
+func (q OrQuery) String() string { return joinQueries(q, " OR ") }
+
+// String implements fmt.Stringer.
+func (q NotQuery) String() string { return "NOT " + q.Query.String() }
+
+func joinQueries(queries []Query, sep string) string {
+	parts := make([]string, len(queries))
+	for i, q := range queries {
+		parts[i] = q.String()
+	}
+	return "(" + strings.Join(parts, sep) + ")"
+}
+
+// FreeTerms returns the terms of all unqualified, non-negated term queries
+// within q. These are the terms that the user is directly searching for.
+func FreeTerms(q Query) []string {
+	var terms []string
+	var walk func(Query)
+	walk = func(q Query) {
+		switch q := q.(type) {
+		case TermQuery:
+			if q.Field == AnyField {
+				terms = append(terms, q.Term)
+			}
+		case AndQuery:
+			for _, q := range q {
+				walk(q)
+			}
+		case OrQuery:
+			for _, q := range q {
+				walk(q)
+			}
+		case NotQuery:
+			// skip
+		}
+	}
+	walk(q)
+	return terms
+}
+
+// ParseQuery parses a search query. The syntax is as follows:
+//
+//   - Bare words such as `firefox` match the path, name or description.
+//   - `field:word` restricts the word to a field, e.g. `license:mit`. See
+//     QueryFields for a list of fields.
+//   - `"quoted words"` match a whole phrase, optionally with a field prefix,
+//     e.g. `description:"web browser"`.
+//   - Adjacent terms are implicitly AND-ed. `AND`, `OR` and `NOT` may be used
+//     explicitly and must be uppercase. NOT binds tighter than AND, which binds
+//     tighter than OR.
+//   - `-term` is the same as `NOT term`.
+//   - Parentheses group terms, e.g. `(gtk OR qt) -license:unfree`.
+func ParseQuery(query string) (Query, error) {
+	p := queryParser{lexer: queryLexer{src: query}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokenEOF {
+		return nil, errors.New("empty query")
+	}
+
+	q, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != tokenEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+
+	return q, nil
+}
+
+type tokenKind uint8
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenPhrase
+	tokenLParen
+	tokenRParen
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenMinus
+)
+
+type queryToken struct {
+	kind  tokenKind
+	pos   int
+	field QueryField // only for tokenWord and tokenPhrase
+	text  string     // only for tokenWord and tokenPhrase
+}
+
+func (t queryToken) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of query"
+	case tokenWord, tokenPhrase:
+		return strconv.Quote(TermQuery{Field: t.field, Term: t.text, Phrase: t.kind == tokenPhrase}.String())
+	case tokenLParen:
+		return `"("`
+	case tokenRParen:
+		return `")"`
+	case tokenAnd:
+		return `"AND"`
+	case tokenOr:
+		return `"OR"`
+	case tokenNot:
+		return `"NOT"`
+	case tokenMinus:
+		return `"-"`
+	default:
+		return "unknown token"
+	}
+}
+
+type queryLexer struct {
+	src string
+	pos int
+}
+
+func (l *queryLexer) peekRune() rune {
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	return r
+}
+
+func isWordBoundary(r rune) bool {
+	return unicode.IsSpace(r) || r == '(' || r == ')' || r == '"'
+}
+
+func (l *queryLexer) next() (queryToken, error) {
+	for l.pos < len(l.src) {
+		r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		l.pos += size
+	}
+
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return queryToken{kind: tokenEOF, pos: start}, nil
+	}
+
+	switch l.src[l.pos] {
+	case '(':
+		l.pos++
+		return queryToken{kind: tokenLParen, pos: start}, nil
+	case ')':
+		l.pos++
+		return queryToken{kind: tokenRParen, pos: start}, nil
+	case '"':
+		phrase, err := l.phrase()
+		if err != nil {
+			return queryToken{}, err
+		}
+		return queryToken{kind: tokenPhrase, pos: start, text: phrase}, nil
+	case '-':
+		// A minus is only a negation if it prefixes something.
+		if l.pos+1 < len(l.src) && !unicode.IsSpace(rune(l.src[l.pos+1])) && l.src[l.pos+1] != ')' {
+			l.pos++
+			return queryToken{kind: tokenMinus, pos: start}, nil
+		}
+	}
+
+	for l.pos < len(l.src) {
+		r := l.peekRune()
+		if isWordBoundary(r) {
+			break
+		}
+		l.pos += utf8.RuneLen(r)
+	}
+
+	word := l.src[start:l.pos]
+	switch word {
+	case "AND":
+		return queryToken{kind: tokenAnd, pos: start}, nil
+	case "OR":
+		return queryToken{kind: tokenOr, pos: start}, nil
+	case "NOT":
+		return queryToken{kind: tokenNot, pos: start}, nil
+	}
+
+	// Words like URLs that only look like a field are searched for as is.
+	name, value, ok := strings.Cut(word, ":")
+	if !ok || !isQueryField(name) {
+		return queryToken{kind: tokenWord, pos: start, text: word}, nil
+	}
+
+	tok := queryToken{
+		kind:  tokenWord,
+		pos:   start,
+		field: QueryField(name),
+		text:  value,
+	}
+
+	if value == "" {
+		if l.pos >= len(l.src) || l.src[l.pos] != '"' {
+			return queryToken{}, fmt.Errorf("at position %d: missing value for field %q", start, name)
+		}
+
+		phrase, err := l.phrase()
+		if err != nil {
+			return queryToken{}, err
+		}
+
+		tok.kind = tokenPhrase
+		tok.text = phrase
+	}
+
+	if tok.field.IsBool() && tok.text != "true" && tok.text != "false" {
+		return queryToken{}, fmt.Errorf("at position %d: field %q must be true or false", start, name)
+	}
+
+	return tok, nil
+}
+
+// phrase reads a quoted phrase starting at the current position.
+func (l *queryLexer) phrase() (string, error) {
+	start := l.pos
+	l.pos++ // skip opening quote
+
+	end := strings.IndexByte(l.src[l.pos:], '"')
+	if end == -1 {
+		return "", fmt.Errorf("at position %d: unterminated quote", start)
+	}
+
+	phrase := l.src[l.pos : l.pos+end]
+	l.pos += end + 1 // skip closing quote
+
+	if strings.TrimSpace(phrase) == "" {
+		return "", fmt.Errorf("at position %d: empty quote", start)
+	}
+
+	return phrase, nil
+}
+
+type queryParser struct {
+	lexer queryLexer
+	tok   queryToken
+}
+
+func (p *queryParser) next() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *queryParser) errorf(f string, v ...any) error {
+	return fmt.Errorf("at position %d: %s", p.tok.pos, fmt.Sprintf(f, v...))
+}
+
+// parseOr parses `and (OR and)*`.
+func (p *queryParser) parseOr() (Query, error) {
+	var queries OrQuery
+	for {
+		q, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+
+		if p.tok.kind != tokenOr {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(queries) == 1 {
+		return queries[0], nil
+	}
+	return queries, nil
+}
+
+// parseAnd parses `unary (AND? unary)*`.
+func (p *queryParser) parseAnd() (Query, error) {
+	var queries AndQuery
+	for {
+		q, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+
+		switch p.tok.kind {
+		case tokenAnd:
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			continue
+		case tokenWord, tokenPhrase, tokenLParen, tokenNot, tokenMinus:
+			continue
+		}
+		break
+	}
+
+	if len(queries) == 1 {
+		return queries[0], nil
+	}
+	return queries, nil
+}
+
+// parseUnary parses `(NOT | -) unary | primary`.
+func (p *queryParser) parseUnary() (Query, error) {
+	switch p.tok.kind {
+	case tokenNot, tokenMinus:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		q, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return NotQuery{Query: q}, nil
+	default:
+		return p.parsePrimary()
+	}
+}
+
+// parsePrimary parses `( or ) | term`.
+func (p *queryParser) parsePrimary() (Query, error) {
+	switch p.tok.kind {
+	case tokenLParen:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokenRParen {
+			return nil, p.errorf("expected %q, got %s", ")", p.tok)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return q, nil
+
+	case tokenWord, tokenPhrase:
+		q := TermQuery{
+			Field:  p.tok.field,
+			Term:   p.tok.text,
+			Phrase: p.tok.kind == tokenPhrase,
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return q, nil
+
+	default:
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+}
@@ -0,0 +1,73 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestParseQuery(t *testing.T) {
+	type expectQuery struct {
+		query string
+		want  string
+	}
+
+	expectQueries := []expectQuery{
+		{`firefox`, `firefox`},
+		{`nix-search`, `nix-search`},
+		{`python3Packages.requests`, `python3Packages.requests`},
+		{`name:firefox`, `name:firefox`},
+		{`"web browser"`, `"web browser"`},
+		{`description:"web browser"`, `description:"web browser"`},
+		{`gtk wayland`, `(gtk AND wayland)`},
+		{`gtk AND wayland`, `(gtk AND wayland)`},
+		{`gtk OR qt`, `(gtk OR qt)`},
+		{`gtk OR qt wayland`, `(gtk OR (qt AND wayland))`},
+		{`(gtk OR qt) wayland`, `((gtk OR qt) AND wayland)`},
+		{`-license:unfree`, `NOT license:unfree`},
+		{`NOT broken`, `NOT broken`},
+		{`gui -license:unfree description:wayland`, `(gui AND NOT license:unfree AND description:wayland)`},
+		{`a - b`, `(a AND - AND b)`},
+		{`and or not`, `(and AND or AND not)`},
+		{`gui -unfree:true`, `(gui AND NOT unfree:true)`},
+		{`broken:"true"`, `broken:"true"`},
+		{`nmae:firefox`, `nmae:firefox`},
+		{`https://www.mozilla.org/firefox/`, `https://www.mozilla.org/firefox/`},
+		{`:firefox`, `:firefox`},
+	}
+
+	for _, expect := range expectQueries {
+		t.Run(expect.query, func(t *testing.T) {
+			q, err := ParseQuery(expect.query)
+			assert.NoError(t, err)
+			assert.Equal(t, expect.want, q.String())
+		})
+	}
+
+	invalidQueries := []string{
+		``,
+		`   `,
+		`(gtk`,
+		`gtk)`,
+		`"unterminated`,
+		`""`,
+		`name:`,
+		`gtk OR`,
+		`NOT`,
+		`unfree:yes`,
+		`unfree:"yes"`,
+	}
+
+	for _, query := range invalidQueries {
+		t.Run("invalid:"+query, func(t *testing.T) {
+			q, err := ParseQuery(query)
+			assert.Error(t, err, "unexpected query %v", q)
+		})
+	}
+}
+
+func TestFreeTerms(t *testing.T) {
+	q, err := ParseQuery(`gui (gtk OR qt) -electron license:mit`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gui", "gtk", "qt"}, FreeTerms(q))
+}
@@ -2,6 +2,8 @@ package search
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"html"
 	"iter"
 	"strings"
@@ -13,9 +15,13 @@ import (
 type PackagesSearcher interface {
 	// SearchPackages returns a channel of packages that match the given query.
 	// The channel is closed when there are no more results or ctx is canceled.
+	// An empty query matches all packages.
 	SearchPackages(ctx context.Context, query string, opts Opts) (iter.Seq[SearchedPackage], error)
 }
 
+// ErrPackageNotFound is returned when a package does not exist.
+var ErrPackageNotFound = errors.New("package not found")
+
 // Opts are options for searching.
 type Opts struct {
 	// Highlight is an optional highlighter for this package.
@@ -29,17 +35,149 @@ type Opts struct {
 	// Note that this filter is applied on top of Bluge's, meaning it narrows
 	// down Bluge's results but does not expand them.
 	Exact bool
+	// Program, if non-empty, only matches packages that provide an executable
+	// with this name, ranking exact matches of the main program highest.
+	Program string
+	// Maintainer, if non-empty, only matches packages maintained by the given
+	// maintainer handle or GitHub username, ignoring case.
+	Maintainer string
+
+	// SortBy is the order to return results in. By default, results are
+	// returned as they are found, or by descending relevance if a page is
+	// requested using Offset or Limit.
+	SortBy SortOrder
+	// Offset is the number of results to skip.
+	Offset int
+	// Limit is the maximum number of results to return. If 0, all results
+	// are returned.
+	Limit int
+
+	// Unfree filters packages with an unfree license.
+	Unfree Filter
+	// Broken filters packages that are marked as broken.
+	Broken Filter
+	// UnsupportedPlatform filters packages that are not available on the
+	// platform that they were indexed on.
+	UnsupportedPlatform Filter
+	// Overlay filters packages that were added by an overlay.
+	Overlay Filter
+	// Systems, if non-empty, only matches packages that are available on all
+	// of the given systems, e.g. "aarch64-linux". Only packages indexed with
+	// these systems in IndexPackagesOpts.Systems can match.
+	Systems []string
+}
+
+// Filter is a tri-state filter over a boolean package attribute.
+type Filter uint8
+
+const (
+	// FilterInclude includes packages regardless of the attribute. This is the
+	// default.
+	FilterInclude Filter = iota
+	// FilterExclude excludes packages that have the attribute.
+	FilterExclude
+	// FilterOnly only includes packages that have the attribute.
+	FilterOnly
+)
+
+// ParseFilter parses a filter from its string representation, which is one of
+// "include", "exclude" or "only".
+func ParseFilter(s string) (Filter, error) {
+	switch s {
+	case "include":
+		return FilterInclude, nil
+	case "exclude":
+		return FilterExclude, nil
+	case "only":
+		return FilterOnly, nil
+	default:
+		return 0, fmt.Errorf("invalid filter %q, must be include, exclude or only", s)
+	}
+}
+
+// String implements fmt.Stringer.
+func (f Filter) String() string {
+	switch f {
+	case FilterInclude:
+		return "include"
+	case FilterExclude:
+		return "exclude"
+	case FilterOnly:
+		return "only"
+	default:
+		return fmt.Sprintf("Filter(%d)", f)
+	}
+}
+
+// SortOrder is the order that search results are returned in.
+type SortOrder uint8
+
+const (
+	// SortByNone returns results as they are found without sorting them, so
+	// that they can be streamed. This is the default.
+	SortByNone SortOrder = iota
+	// SortByScore sorts results by descending relevance.
+	SortByScore
+	// SortByName sorts results by package name, ignoring case.
+	SortByName
+	// SortByPath sorts results by attribute path.
+	SortByPath
+	// SortByVersion sorts results by version, comparing numbers numerically.
+	SortByVersion
+)
+
+// ParseSortOrder parses a sort order from its string representation, which is
+// one of "score", "name", "path" or "version". An empty string or "none" is
+// SortByNone.
+func ParseSortOrder(s string) (SortOrder, error) {
+	switch s {
+	case "", "none":
+		return SortByNone, nil
+	case "score":
+		return SortByScore, nil
+	case "name":
+		return SortByName, nil
+	case "path":
+		return SortByPath, nil
+	case "version":
+		return SortByVersion, nil
+	default:
+		return 0, fmt.Errorf("invalid sort order %q, must be score, name, path or version", s)
+	}
+}
+
+// String implements fmt.Stringer.
+func (o SortOrder) String() string {
+	switch o {
+	case SortByNone:
+		return "none"
+	case SortByScore:
+		return "score"
+	case SortByName:
+		return "name"
+	case SortByPath:
+		return "path"
+	case SortByVersion:
+		return "version"
+	default:
+		return fmt.Sprintf("SortOrder(%d)", o)
+	}
 }
 
 // SearchedPackage is a package that was searched for.
 type SearchedPackage struct {
 	// Path is the path to the derivation.
 	Path string `json:"path"`
+	// Source is the name of the index that the package was found in, if the
+	// searcher searches multiple indexes.
+	Source string `json:"source,omitempty"`
+	// Score is the relevance score of the package. Higher is more relevant.
+	Score float64 `json:"score,omitempty"`
 	Package
 
 	// Highlighted is the color-highlighted package, if any.
 	// This is only used if Highlight is set in Opts.
-	Highlighted *SearchedPackage `json:"unhighlighted"`
+	Highlighted *SearchedPackage `json:"highlighted,omitempty"`
 }
 
 // HighlightStyle is a style of highlighting.
@@ -6,36 +6,52 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/blugelabs/bluge"
 	"libdb.so/nix-search/search"
-
-	blugeindex "github.com/blugelabs/bluge/index"
 )
 
-func batchPackageSet(packages search.TopLevelPackages) (*blugeindex.Batch, error) {
-	batch := bluge.NewBatch()
-	packages.Walk(func(path search.Path, drv search.Package) bool {
-		doc := newPackageDocument(path, drv)
-		batch.Update(doc.ID(), doc)
-		return true
-	})
-	return batch, nil
-}
-
-func newPackageDocument(path search.Path, pkg search.Package) *bluge.Document {
+func packageJSON(pkg search.Package) []byte {
 	drvJSON, err := json.Marshal(pkg)
 	if err != nil {
 		log.Panicln("cannot marshal derivation:", err)
 	}
+	return drvJSON
+}
 
+func newPackageDocument(path search.Path, pkg search.Package, drvJSON []byte) *bluge.Document {
 	doc := bluge.NewDocument(path.String())
 	doc.AddField(bluge.NewStoredOnlyField("json", drvJSON))
 	// hack because bluge is kinda balls and doesn't treat . as a word boundary
 	doc.AddField(newField("path", strings.Join(path.Parts(), " ")))
 	doc.AddField(newField("name", pkg.Name))
+	doc.AddField(bluge.NewKeywordField("sort_name", strings.ToLower(pkg.Name)).Sortable())
 	doc.AddField(newField("description", pkg.Description))
+	doc.AddField(newField("license", strings.Join(pkg.Licenses, " ")))
+	doc.AddField(newField("program", strings.Join(programs(pkg), " ")))
+	if pkg.MainProgram != "" {
+		doc.AddField(bluge.NewKeywordField("main_program", strings.ToLower(pkg.MainProgram)))
+	}
+	for _, program := range programs(pkg) {
+		doc.AddField(bluge.NewKeywordField("bin", strings.ToLower(program)))
+	}
+	doc.AddField(newField("version", pkg.Version))
+	doc.AddField(bluge.NewKeywordField("sort_version", versionSortKey(pkg.Version)).Sortable())
+	doc.AddField(newField("homepage", strings.Join(pkg.Homepages, " ")))
+	doc.AddField(newField("team", strings.Join(pkg.Teams, " ")))
+	for _, maintainer := range maintainerKeywords(pkg.Maintainers) {
+		doc.AddField(bluge.NewKeywordField("maintainer", maintainer))
+	}
+	doc.AddField(newBoolField("broken", pkg.Broken))
+	doc.AddField(newBoolField("unfree", pkg.Unfree))
+	doc.AddField(newBoolField("unsupported", pkg.UnsupportedPlatform))
+	doc.AddField(newBoolField("overlay", pkg.Overlay))
+	for _, system := range pkg.Systems {
+		doc.AddField(bluge.NewKeywordField("system", system))
+	}
 
 	return doc
 }
@@ -58,3 +74,38 @@ func newField(name string, value string) *bluge.TermField {
 		HighlightMatches().
 		SearchTermPositions()
 }
+
+// programs returns the main program of the package followed by all other
+// known executables, without duplicates.
+func programs(pkg search.Package) []string {
+	programs := make([]string, 0, 1+len(pkg.Programs))
+	if pkg.MainProgram != "" {
+		programs = append(programs, pkg.MainProgram)
+	}
+	for _, program := range pkg.Programs {
+		if !slices.Contains(programs, program) {
+			programs = append(programs, program)
+		}
+	}
+	return programs
+}
+
+// maintainerKeywords returns the lowercased handles and GitHub usernames of
+// the given maintainers without duplicates.
+func maintainerKeywords(maintainers []search.Maintainer) []string {
+	keywords := make([]string, 0, len(maintainers))
+	for _, m := range maintainers {
+		for _, k := range []string{m.Handle, m.GitHub} {
+			k = strings.ToLower(k)
+			if k != "" && !slices.Contains(keywords, k) {
+				keywords = append(keywords, k)
+			}
+		}
+	}
+	return keywords
+}
+
+// newBoolField creates a keyword field that is either "true" or "false".
+func newBoolField(name string, value bool) *bluge.TermField {
+	return bluge.NewKeywordField(name, strconv.FormatBool(value))
+}
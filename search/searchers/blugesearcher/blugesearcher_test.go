@@ -2,12 +2,17 @@ package blugesearcher
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/alecthomas/assert/v2"
 	"github.com/hashicorp/go-hclog"
 	"libdb.so/nix-search/search"
+
+	blugesearch "github.com/blugelabs/bluge/search"
 )
 
 func TestMain(m *testing.M) {
@@ -24,14 +29,39 @@ func TestBluge(t *testing.T) {
 			"nix-search": search.Package{
 				Name:        "nix-search",
 				Description: "Search for packages in Nixpkgs.",
+				Maintainers: []search.Maintainer{{Handle: "diamond", GitHub: "diamondburned"}},
 			},
 			"nix-index": search.Package{
 				Name:        "nix-index",
 				Description: "Index Nixpkgs.",
+				Overlay:     true,
 			},
 			"firefox": search.Package{
 				Name:        "firefox",
 				Description: "Firefox is a free and open-source web browser developed by the Mozilla Foundation and its subsidiary, the Mozilla Corporation.",
+				Licenses:    []string{"MPL-2.0"},
+				MainProgram: "firefox",
+				Homepages:   []string{"https://www.mozilla.org/firefox/"},
+				Teams:       []string{"mozilla"},
+				Systems:     []string{"x86_64-linux", "aarch64-linux", "aarch64-darwin"},
+			},
+			"flashplayer": search.Package{
+				Name:        "flashplayer",
+				Description: "Adobe Flash Player web browser plugin.",
+				Unfree:      true,
+				Broken:      true,
+				Systems:     []string{"x86_64-linux"},
+			},
+			"ripgrep": search.Package{
+				Name:        "ripgrep",
+				Description: "Utility that combines the usability of The Silver Searcher with the raw speed of grep.",
+				MainProgram: "rg",
+			},
+			"ripgrep-all": search.Package{
+				Name:        "ripgrep-all",
+				Description: "Ripgrep, but also search in PDFs, E-Books, Office documents, zip, tar.gz, and more.",
+				MainProgram: "rga",
+				Programs:    []string{"rga", "rga-preproc", "rga-fzf"},
 			},
 			"goPackages": search.PackageSet{
 				"staticcheck": search.Package{
@@ -41,6 +71,7 @@ func TestBluge(t *testing.T) {
 				"bluge": search.Package{
 					Name:        "bluge",
 					Description: "Bluge is a high-performance, high-level full-text search engine library written in Go.",
+					Licenses:    []string{"Apache-2.0"},
 				},
 			},
 		},
@@ -90,6 +121,16 @@ func TestBluge(t *testing.T) {
 			{"nix-search", []string{"nix-search"}},
 			{"fire", []string{"firefox"}},
 			{"go", []string{"staticcheck", "bluge"}},
+			{"description:browser", []string{"firefox"}},
+			{`description:"web browser"`, []string{"firefox"}},
+			{"license:apache", []string{"bluge"}},
+			{"program:firefox", []string{"firefox"}},
+			{"path:goPackages.bluge", []string{"bluge"}},
+			{"homepage:mozilla", []string{"firefox"}},
+			{"team:mozilla", []string{"firefox"}},
+			{"maintainer:diamondburned", []string{"nix-search"}},
+			{"maintainer:Diamond", []string{"nix-search"}},
+			{"nix-search OR firefox", []string{"nix-search", "firefox"}},
 		}
 
 		for _, expect := range expectSearches {
@@ -126,6 +167,10 @@ func TestBluge(t *testing.T) {
 
 		unexpectedSearches := []unexpectedSearch{
 			{"asldjkoasdjasjdasd"},
+			{"name:firefox license:apache"},
+			{"program:firefox -description:browser"},
+			{"flashplayer unfree:false"},
+			{"maintainer:diamondbur"},
 		}
 
 		for _, unexpected := range unexpectedSearches {
@@ -142,6 +187,82 @@ func TestBluge(t *testing.T) {
 			})
 		}
 	})
+
+	t.Run("program", func(t *testing.T) {
+		type expectProgram struct {
+			query   string
+			program string
+			want    []string // in order
+		}
+
+		expectPrograms := []expectProgram{
+			{"", "rg", []string{"ripgrep", "ripgrep-all"}},
+			{"", "rga-preproc", []string{"ripgrep-all"}},
+			{"pdf", "rg", []string{"ripgrep-all"}},
+		}
+
+		for _, expect := range expectPrograms {
+			t.Run(expect.query+":"+expect.program, func(t *testing.T) {
+				results, err := searcher.SearchPackages(ctx, expect.query, search.Opts{
+					Program: expect.program,
+				})
+				assert.NoError(t, err, "cannot search for", expect.program)
+
+				var got []string
+				for pkg := range results {
+					got = append(got, pkg.Name)
+				}
+
+				assert.Equal(t, expect.want, got)
+			})
+		}
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		type expectFilter struct {
+			name  string
+			query string
+			opts  search.Opts
+			want  []string
+		}
+
+		expectFilters := []expectFilter{
+			{"include", "browser", search.Opts{}, []string{"firefox", "flashplayer"}},
+			{"exclude-unfree", "browser", search.Opts{Unfree: search.FilterExclude}, []string{"firefox"}},
+			{"only-unfree", "browser", search.Opts{Unfree: search.FilterOnly}, []string{"flashplayer"}},
+			{"exclude-broken", "browser", search.Opts{Broken: search.FilterExclude}, []string{"firefox"}},
+			{"exclude-unsupported", "browser", search.Opts{UnsupportedPlatform: search.FilterExclude}, []string{"firefox", "flashplayer"}},
+			{"only-unsupported", "browser", search.Opts{UnsupportedPlatform: search.FilterOnly}, nil},
+			{"system", "browser", search.Opts{Systems: []string{"x86_64-linux"}}, []string{"firefox", "flashplayer"}},
+			{"systems", "browser", search.Opts{Systems: []string{"x86_64-linux", "aarch64-linux"}}, []string{"firefox"}},
+			{"unknown-system", "browser", search.Opts{Systems: []string{"riscv64-linux"}}, nil},
+			{"maintainer", "", search.Opts{Maintainer: "Diamond"}, []string{"nix-search"}},
+			{"maintainer-query", "nixpkgs", search.Opts{Maintainer: "diamondburned"}, []string{"nix-search"}},
+			{"maintainer-syntax", "", search.Opts{Maintainer: `diamond (" OR`}, nil},
+			{"only-overlay", "description:nixpkgs", search.Opts{Overlay: search.FilterOnly}, []string{"nix-index"}},
+			{"exclude-overlay", "description:nixpkgs", search.Opts{Overlay: search.FilterExclude}, []string{"nix-search"}},
+			{"overlay-query", "description:nixpkgs overlay:true", search.Opts{}, []string{"nix-index"}},
+			{"system-query", "browser system:aarch64-darwin", search.Opts{}, []string{"firefox"}},
+			{"exact", "ripgrep", search.Opts{Exact: true}, []string{"ripgrep", "ripgrep-all"}},
+			{"exact-or", "firefox OR ripgrep-all", search.Opts{Exact: true}, []string{"firefox", "ripgrep-all"}},
+			{"exact-grouped", "(firefox OR flash) description:browser", search.Opts{Exact: true}, []string{"firefox", "flashplayer"}},
+			{"exact-and", "ripgrep PDFs", search.Opts{Exact: true}, []string{"ripgrep-all"}},
+		}
+
+		for _, expect := range expectFilters {
+			t.Run(expect.name, func(t *testing.T) {
+				results, err := searcher.SearchPackages(ctx, expect.query, expect.opts)
+				assert.NoError(t, err, "cannot search for", expect.query)
+
+				var got []string
+				for result := range results {
+					got = append(got, result.Name)
+				}
+
+				assert.Equal(t, setFromList(expect.want), setFromList(got))
+			})
+		}
+	})
 }
 
 func setFromList[T comparable](list []T) map[T]bool {
@@ -152,3 +273,313 @@ func setFromList[T comparable](list []T) map[T]bool {
 	return set
 
 }
+
+func TestSortPackages(t *testing.T) {
+	ctx := context.Background()
+	tempIndex := t.TempDir()
+
+	err := IndexPackages(ctx, tempIndex, search.TopLevelPackages{
+		Nixpkgs: "nixpkgs",
+		PackageSet: search.PackageSet{
+			"b": search.Package{Name: "Beta", Version: "1.10", Description: "a tool"},
+			"a": search.Package{Name: "gamma", Version: "1.9", Description: "a tool"},
+			"c": search.Package{Name: "alpha", Version: "2.0", Description: "a tool"},
+			"d": search.Package{Name: "delta", Version: "0.1", Description: "a toolkit"},
+		},
+	})
+	assert.NoError(t, err, "cannot index packages")
+
+	searcher, err := Open(tempIndex)
+	assert.NoError(t, err, "cannot open searcher")
+	defer searcher.Close()
+
+	tests := []struct {
+		name string
+		opts search.Opts
+		want []string
+	}{
+		{"name", search.Opts{SortBy: search.SortByName}, []string{"alpha", "Beta", "gamma"}},
+		{"path", search.Opts{SortBy: search.SortByPath}, []string{"gamma", "Beta", "alpha"}},
+		{"version", search.Opts{SortBy: search.SortByVersion}, []string{"gamma", "Beta", "alpha"}},
+		{"limit", search.Opts{SortBy: search.SortByName, Limit: 2}, []string{"alpha", "Beta"}},
+		{"offset", search.Opts{SortBy: search.SortByName, Offset: 1, Limit: 1}, []string{"Beta"}},
+		{"exact", search.Opts{SortBy: search.SortByName, Exact: true, Offset: 1}, []string{"Beta", "gamma"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := searcher.SearchPackages(ctx, `description:"a tool"`, test.opts)
+			assert.NoError(t, err, "cannot search")
+
+			var got []string
+			for result := range results {
+				got = append(got, result.Name)
+			}
+
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestMatchesExactly(t *testing.T) {
+	result := search.SearchedPackage{
+		Path: "nixpkgs.ripgrep-all",
+		Package: search.Package{
+			Name:        "ripgrep-all",
+			Description: "Ripgrep, but also search in PDFs and more.",
+		},
+	}
+
+	match := &blugesearch.DocumentMatch{
+		Locations: blugesearch.FieldTermLocationMap{
+			"name": {"rip": {{Start: 0, End: 3}}},
+		},
+	}
+
+	assert.True(t, matchesExactly(match, true, result, search.AndQuery{
+		search.TermQuery{Term: "ripgrep"},
+		search.TermQuery{Term: "all"},
+		search.TermQuery{Term: "PDFs"},
+	}))
+	assert.Equal(t, blugesearch.FieldTermLocationMap{
+		"path": {
+			"ripgrep": {{Start: 8, End: 15}},
+			"all":     {{Start: 16, End: 19}},
+		},
+		"name": {
+			// Fields without an exact match keep their locations.
+			"rip": {{Start: 0, End: 3}},
+		},
+		"description": {
+			"PDFs": {{Start: 28, End: 32}},
+		},
+	}, match.Locations)
+
+	assert.False(t, matchesExactly(match, false, result, search.AndQuery{
+		search.TermQuery{Term: "ripgrep"},
+		search.TermQuery{Term: "fzf"},
+	}))
+
+	t.Run("or", func(t *testing.T) {
+		match := &blugesearch.DocumentMatch{}
+		assert.True(t, matchesExactly(match, true, result, search.OrQuery{
+			search.TermQuery{Term: "fzf"},
+			search.TermQuery{Term: "PDFs"},
+		}))
+		assert.Equal(t, blugesearch.FieldTermLocationMap{
+			"description": {
+				"PDFs": {{Start: 28, End: 32}},
+			},
+		}, match.Locations)
+
+		assert.False(t, matchesExactly(match, false, result, search.OrQuery{
+			search.TermQuery{Term: "fzf"},
+			search.TermQuery{Term: "skim"},
+		}))
+	})
+}
+
+func TestVersionSortKey(t *testing.T) {
+	versions := []string{"0.1", "1.9", "1.10", "1.10.1", "2.0-rc1", "2.0-rc10", "10.0"}
+	for i := 1; i < len(versions); i++ {
+		a, b := versions[i-1], versions[i]
+		assert.True(t, versionSortKey(a) < versionSortKey(b), "%q should sort before %q", a, b)
+	}
+}
+
+func TestUpdatePackages(t *testing.T) {
+	ctx := context.Background()
+	tempIndex := t.TempDir()
+
+	packages := search.TopLevelPackages{
+		Nixpkgs: "nixpkgs",
+		PackageSet: search.PackageSet{
+			"hello":   search.Package{Name: "hello", Version: "2.12"},
+			"cowsay":  search.Package{Name: "cowsay", Version: "3.7.0"},
+			"fortune": search.Package{Name: "fortune", Version: "3.20.0"},
+		},
+	}
+
+	report, err := UpdatePackages(ctx, tempIndex, packages)
+	assert.NoError(t, err, "cannot create index")
+	assert.Equal(t, IndexReport{Added: 3}, report)
+
+	packages.PackageSet = search.PackageSet{
+		"hello":  search.Package{Name: "hello", Version: "2.12.1"},
+		"cowsay": search.Package{Name: "cowsay", Version: "3.7.0"},
+		"figlet": search.Package{Name: "figlet", Version: "2.2.5"},
+		"lolcat": search.Package{Name: "lolcat", Version: "100.0.1"},
+		"sl":     search.Package{Name: "sl", Version: "5.05"},
+	}
+
+	report, err = UpdatePackages(ctx, tempIndex, packages)
+	assert.NoError(t, err, "cannot update index")
+	assert.Equal(t, IndexReport{Added: 3, Removed: 1, Updated: 1, Unchanged: 1}, report)
+
+	searcher, err := Open(tempIndex)
+	assert.NoError(t, err, "cannot open searcher")
+	defer searcher.Close()
+
+	count, err := searcher.reader.Count()
+	assert.NoError(t, err, "cannot count packages")
+	assert.Equal(t, 5, int(count), "wrong number of packages")
+}
+
+func TestSources(t *testing.T) {
+	ctx := context.Background()
+
+	sources, err := OpenSources(t.TempDir())
+	assert.NoError(t, err, "cannot open sources")
+
+	names, err := sources.Names()
+	assert.NoError(t, err, "cannot list sources")
+	assert.Equal(t, 0, len(names), "unexpected sources")
+
+	err = sources.Index(ctx, "nixos-24.05", search.TopLevelPackages{
+		Nixpkgs: "nixos-24.05",
+		PackageSet: search.PackageSet{
+			"hello": search.Package{Name: "hello", Version: "2.12.1"},
+		},
+	})
+	assert.NoError(t, err, "cannot index nixos-24.05")
+
+	err = sources.Index(ctx, "github:nix-community/home-manager", search.TopLevelPackages{
+		Nixpkgs: "github:nix-community/home-manager",
+		Flake:   true,
+		PackageSet: search.PackageSet{
+			"hello":        search.Package{Name: "hello", Version: "2.12.2"},
+			"home-manager": search.Package{Name: "home-manager", Version: "0-unstable"},
+		},
+	})
+	assert.NoError(t, err, "cannot index home-manager")
+
+	// Sources indexed by an older version are not searched.
+	err = os.MkdirAll(filepath.Join(sources.SourcePath("nixos-23.11"), "index-v4"), 0755)
+	assert.NoError(t, err, "cannot create outdated source")
+
+	names, err = sources.Names()
+	assert.NoError(t, err, "cannot list sources")
+	assert.Equal(t, []string{"github:nix-community/home-manager", "nixos-24.05"}, names)
+
+	outdated, err := sources.Outdated()
+	assert.NoError(t, err, "cannot list outdated sources")
+	assert.Equal(t, []string{"nixos-23.11"}, outdated)
+
+	manifest, err := sources.Manifest("github:nix-community/home-manager")
+	assert.NoError(t, err, "cannot read manifest")
+	assert.Equal(t, "github:nix-community/home-manager", manifest.Name)
+	assert.Equal(t, 2, manifest.Packages)
+
+	t.Run("all", func(t *testing.T) {
+		searcher, err := sources.Open()
+		assert.NoError(t, err, "cannot open all sources")
+		defer searcher.Close()
+
+		results, err := searcher.SearchPackages(ctx, "hello", search.Opts{})
+		assert.NoError(t, err, "cannot search")
+
+		got := make(map[string]string)
+		for result := range results {
+			got[result.Path] = result.Source
+		}
+
+		assert.Equal(t, map[string]string{
+			"nixos-24.05.hello":                       "nixos-24.05",
+			"github:nix-community/home-manager#hello": "github:nix-community/home-manager",
+		}, got)
+	})
+
+	t.Run("paginate", func(t *testing.T) {
+		searcher, err := sources.Open()
+		assert.NoError(t, err, "cannot open all sources")
+		defer searcher.Close()
+
+		results, err := searcher.SearchPackages(ctx, "hello", search.Opts{
+			SortBy: search.SortByPath,
+			Offset: 1,
+			Limit:  1,
+		})
+		assert.NoError(t, err, "cannot search")
+
+		var paths []string
+		for result := range results {
+			paths = append(paths, result.Path)
+		}
+
+		assert.Equal(t, []string{"nixos-24.05.hello"}, paths)
+	})
+
+	t.Run("package", func(t *testing.T) {
+		searcher, err := sources.Open()
+		assert.NoError(t, err, "cannot open all sources")
+		defer searcher.Close()
+
+		pkg, err := searcher.PackageByPath(ctx, search.FromDotPath("nixos-24.05.hello"))
+		assert.NoError(t, err, "cannot get package")
+		assert.Equal(t, "nixos-24.05", pkg.Source)
+		assert.Equal(t, "2.12.1", pkg.Version)
+
+		pkg, err = searcher.PackageByPath(ctx, search.FromDotPath("github:nix-community/home-manager#home-manager"))
+		assert.NoError(t, err, "cannot get flake package")
+		assert.Equal(t, "0-unstable", pkg.Version)
+
+		_, err = searcher.PackageByPath(ctx, search.FromDotPath("nixos-24.05.home-manager"))
+		assert.True(t, errors.Is(err, search.ErrPackageNotFound), "expected ErrPackageNotFound, got", err)
+	})
+
+	t.Run("one", func(t *testing.T) {
+		searcher, err := sources.Open("nixos-24.05")
+		assert.NoError(t, err, "cannot open source")
+		defer searcher.Close()
+
+		results, err := searcher.SearchPackages(ctx, "hello", search.Opts{})
+		assert.NoError(t, err, "cannot search")
+
+		var paths []string
+		for result := range results {
+			paths = append(paths, result.Path)
+		}
+
+		assert.Equal(t, []string{"nixos-24.05.hello"}, paths)
+	})
+}
+
+func TestIndexWriter(t *testing.T) {
+	ctx := context.Background()
+	tempIndex := t.TempDir()
+
+	w, err := NewIndexWriter(tempIndex)
+	assert.NoError(t, err, "cannot create index writer")
+	defer w.Close()
+
+	root := search.NewPath([]string{"nixpkgs"}, false)
+	perlPackages := root.Push("perlPackages")
+
+	// Add enough packages to span several batches.
+	n := indexBatchSize*2 + 1
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("pkg%d", i)
+		err := w.Add(root.Push(name), search.Package{Name: name, Version: "1.0"})
+		assert.NoError(t, err, "cannot add package")
+	}
+	assert.NoError(t, w.Add(perlPackages, search.PackageSet{}))
+	assert.NoError(t, w.Add(perlPackages.Push("JSON"), search.Package{Name: "perl-JSON", Version: "4.10"}))
+
+	manifest, err := w.Commit(ctx, search.TopLevelPackages{Nixpkgs: "nixpkgs"})
+	assert.NoError(t, err, "cannot commit index")
+	assert.Equal(t, "nixpkgs", manifest.Name)
+	assert.Equal(t, n+1, manifest.Packages)
+	assert.Equal(t, 1, manifest.Sets)
+
+	searcher, err := Open(tempIndex)
+	assert.NoError(t, err, "cannot open searcher")
+	defer searcher.Close()
+
+	count, err := searcher.reader.Count()
+	assert.NoError(t, err, "cannot count packages")
+	assert.Equal(t, n+1, int(count), "wrong number of packages")
+
+	pkg, err := searcher.PackageByPath(ctx, perlPackages.Push("JSON"))
+	assert.NoError(t, err, "cannot get nested package")
+	assert.Equal(t, "4.10", pkg.Version)
+}
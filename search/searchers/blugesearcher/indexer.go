@@ -2,12 +2,12 @@ package blugesearcher
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/blugelabs/bluge"
-	"github.com/hashicorp/go-hclog"
 	"libdb.so/nix-search/search"
 )
 
@@ -16,6 +16,16 @@ var indexVersions = []string{
 	"index-v2",
 	"index-v3",
 	"index-v4", // better flakes displaying
+	// index-v5 adds:
+	//   - license, program and version fields for structured queries
+	//   - broken, unfree and unsupported fields for filtering
+	//   - homepage and team fields
+	//   - maintainer field
+	//   - main_program and bin fields
+	//   - sort_name and sort_version fields for sorting
+	//   - system field for platform filtering
+	//   - overlay field
+	"index-v5",
 }
 
 var lastIndexVersion = indexVersions[len(indexVersions)-1]
@@ -26,67 +36,28 @@ type PackagesIndexer struct {
 }
 
 // IndexPackages indexes the given packages. If path is empty, the default
-// path is used.
+// path is used. To index packages while they are being evaluated, use
+// IndexWriter instead.
 func IndexPackages(ctx context.Context, path string, packages search.TopLevelPackages) error {
-	if path == "" {
-		var err error
-
-		path, err = defaultIndexPath()
-		if err != nil {
-			return fmt.Errorf("cannot get default index path: %w", err)
-		}
-	}
-
-	if err := os.MkdirAll(path, 0755); err != nil {
-		return fmt.Errorf("cannot create index directory: %w", err)
-	}
-
 	// Plan:
 	// 1. Create a temporary directory which will be used as the new index.
 	// 2. Index the packages into the new index.
 	// 3. Swap the new index with the old index.
 	// 4. Delete the old index (now the new index).
 
-	batch, err := batchPackageSet(packages)
+	w, err := NewIndexWriter(path)
 	if err != nil {
-		return fmt.Errorf("cannot batch package set: %w", err)
-	}
-
-	newPath, err := os.MkdirTemp(path, "index-tmp-*")
-	if err != nil {
-		return fmt.Errorf("cannot create new index snapshot: %w", err)
-	}
-	defer os.RemoveAll(newPath)
-
-	writer, err := bluge.OpenWriter(bluge.DefaultConfig(newPath))
-	if err != nil {
-		return fmt.Errorf("cannot open bluge writer: %w", err)
-	}
-	defer writer.Close()
-
-	if err := writer.Batch(batch); err != nil {
-		return fmt.Errorf("cannot batch index: %w", err)
-	}
-
-	if err := writer.Close(); err != nil {
-		return fmt.Errorf("cannot close index: %w", err)
-	}
-
-	if err := swapDir(path, lastIndexVersion, filepath.Base(newPath)); err != nil {
-		return fmt.Errorf("cannot commit new index: %w", err)
-	}
-
-	if err := os.RemoveAll(newPath); err != nil {
-		log := hclog.FromContext(ctx)
-		log.Error("cannot remove new index snapshot", "path", newPath, "error", err)
+		return err
 	}
+	defer w.Close()
 
-	if err := cleanOldIndexFolders(path); err != nil {
-		log := hclog.FromContext(ctx)
-		log.Warn("cannot clean old index folders", "path", path, "error", err)
+	root := search.NewPath([]string{packages.Nixpkgs}, packages.Flake)
+	if err := w.addPackageSet(root, packages.PackageSet); err != nil {
+		return err
 	}
 
-	return nil
+	_, err = w.Commit(ctx, packages)
+	return err
 }
 
 // swapDir atomically swaps two directories.
@@ -116,3 +87,144 @@ func cleanOldIndexFolders(indexPath string) error {
 	}
 	return nil
 }
+
+// IndexReport reports the changes made to an index by UpdatePackages.
+type IndexReport struct {
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+}
+
+// String implements fmt.Stringer.
+func (r IndexReport) String() string {
+	return fmt.Sprintf(
+		"%d added, %d removed, %d updated, %d unchanged",
+		r.Added, r.Removed, r.Updated, r.Unchanged)
+}
+
+// UpdatePackages incrementally updates the index at the given path to contain
+// exactly the given packages. Only packages that were added, removed or
+// changed since the last indexing are written. If the index does not exist
+// yet, a full index is built using IndexPackages. If path is empty, the
+// default path is used.
+func UpdatePackages(ctx context.Context, path string, packages search.TopLevelPackages) (IndexReport, error) {
+	if path == "" {
+		var err error
+
+		path, err = defaultIndexPath()
+		if err != nil {
+			return IndexReport{}, fmt.Errorf("cannot get default index path: %w", err)
+		}
+	}
+
+	if !Exists(path) {
+		if err := IndexPackages(ctx, path, packages); err != nil {
+			return IndexReport{}, err
+		}
+		return IndexReport{Added: packages.Count()}, nil
+	}
+
+	writer, err := bluge.OpenWriter(bluge.DefaultConfig(filepath.Join(path, lastIndexVersion)))
+	if err != nil {
+		return IndexReport{}, fmt.Errorf("cannot open bluge writer: %w", err)
+	}
+	defer writer.Close()
+
+	reader, err := writer.Reader()
+	if err != nil {
+		return IndexReport{}, fmt.Errorf("cannot open bluge reader: %w", err)
+	}
+
+	stored, err := storedPackageHashes(ctx, reader)
+	reader.Close()
+	if err != nil {
+		return IndexReport{}, fmt.Errorf("cannot read stored packages: %w", err)
+	}
+
+	var report IndexReport
+	batch := bluge.NewBatch()
+
+	packages.Walk(func(path search.Path, pkg search.Package) bool {
+		id := path.String()
+		drvJSON := packageJSON(pkg)
+
+		oldHash, ok := stored[id]
+		delete(stored, id)
+
+		switch {
+		case !ok:
+			report.Added++
+		case oldHash != sha256.Sum256(drvJSON):
+			report.Updated++
+		default:
+			report.Unchanged++
+			return true
+		}
+
+		doc := newPackageDocument(path, pkg, drvJSON)
+		batch.Update(doc.ID(), doc)
+		return true
+	})
+
+	for id := range stored {
+		batch.Delete(bluge.Identifier(id))
+		report.Removed++
+	}
+
+	if err := writer.Batch(batch); err != nil {
+		return IndexReport{}, fmt.Errorf("cannot batch index: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return IndexReport{}, fmt.Errorf("cannot close index: %w", err)
+	}
+
+	if err := writeManifest(filepath.Join(path, lastIndexVersion), newManifest(packages)); err != nil {
+		return IndexReport{}, err
+	}
+
+	return report, nil
+}
+
+// storedPackageHashes returns the SHA-256 hashes of the stored JSON of all
+// packages in the index, keyed by their document ID.
+func storedPackageHashes(ctx context.Context, reader *bluge.Reader) (map[string][sha256.Size]byte, error) {
+	request := bluge.NewAllMatches(bluge.NewMatchAllQuery())
+
+	matchIter, err := reader.Search(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("cannot search: %w", err)
+	}
+
+	hashes := make(map[string][sha256.Size]byte)
+	for {
+		match, err := matchIter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("cannot iterate matches: %w", err)
+		}
+
+		if match == nil {
+			break
+		}
+
+		var id string
+		var hash [sha256.Size]byte
+		err = match.VisitStoredFields(func(field string, value []byte) bool {
+			switch field {
+			case "_id":
+				id = string(value)
+			case "json":
+				hash = sha256.Sum256(value)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot visit stored fields: %w", err)
+		}
+
+		hashes[id] = hash
+	}
+
+	return hashes, nil
+}
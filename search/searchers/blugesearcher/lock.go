@@ -0,0 +1,61 @@
+package blugesearcher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrLocked is returned by Sources.Lock if the source is already locked by
+// another process and wait is false.
+var ErrLocked = errors.New("source is locked by another process")
+
+// Lock acquires an exclusive lock on the named source, which is held while
+// the source is being indexed so that concurrent invocations do not race. If
+// wait is false and the lock is held elsewhere, ErrLocked is returned.
+// The returned function releases the lock.
+func (s *Sources) Lock(name string, wait bool) (unlock func() error, err error) {
+	f, err := s.LockFile(name, wait)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		return f.Close()
+	}, nil
+}
+
+// LockFile is like Lock, but returns the locked file instead. The lock belongs
+// to the open file rather than the process, so it can be handed to a child
+// process, which then holds it until its copy is closed as well. Closing the
+// file releases the lock once no other process holds a copy.
+func (s *Sources) LockFile(name string, wait bool) (*os.File, error) {
+	path := s.SourcePath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("cannot create sources directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open lock file: %w", err)
+	}
+
+	how := unix.LOCK_EX
+	if !wait {
+		how |= unix.LOCK_NB
+	}
+
+	if err := unix.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, fmt.Errorf("cannot lock source: %w", err)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,100 @@
+package blugesearcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"libdb.so/nix-search/search"
+)
+
+// manifestFile is the name of the manifest file within the index directory.
+// Bluge ignores files that it does not recognize, so the manifest lives next
+// to the segments and is swapped in atomically along with them.
+const manifestFile = "manifest.json"
+
+// Manifest describes what an index contains and where it was indexed from.
+type Manifest struct {
+	search.SourceInfo
+	// Name is the name of the top-level package set, which is the first
+	// component of every package path.
+	Name string `json:"name"`
+	// Packages is the number of indexed packages.
+	Packages int `json:"packages"`
+	// Sets is the number of indexed package sets.
+	Sets int `json:"sets"`
+	// Version is the version of nix-search that created the index.
+	Version string `json:"version"`
+	// CheckedAt is when the source was last checked for being stale. It is
+	// zero if it was never checked since it was indexed.
+	CheckedAt time.Time `json:"checkedAt"`
+	// Stale is the result of the check at CheckedAt.
+	Stale bool `json:"stale,omitempty"`
+}
+
+func newManifest(packages search.TopLevelPackages) Manifest {
+	return Manifest{
+		SourceInfo: packages.Info,
+		Name:       packages.Nixpkgs,
+		Packages:   packages.Count(),
+		Sets:       packages.CountSets(),
+		Version:    search.Version(),
+	}
+}
+
+// ReadManifest reads the manifest of the index at the given path. If path is
+// empty, the default path is used.
+func ReadManifest(path string) (Manifest, error) {
+	if path == "" {
+		var err error
+
+		path, err = defaultIndexPath()
+		if err != nil {
+			return Manifest{}, fmt.Errorf("cannot get default index path: %w", err)
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join(path, lastIndexVersion, manifestFile))
+	if err != nil {
+		return Manifest{}, fmt.Errorf("cannot read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return Manifest{}, fmt.Errorf("cannot parse manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// writeManifest atomically writes the manifest into the given Bluge index
+// directory.
+func writeManifest(indexDir string, manifest Manifest) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal manifest: %w", err)
+	}
+
+	f, err := os.CreateTemp(indexDir, manifestFile+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("cannot create manifest: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Write(b); err != nil {
+		return fmt.Errorf("cannot write manifest: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cannot close manifest: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), filepath.Join(indexDir, manifestFile)); err != nil {
+		return fmt.Errorf("cannot commit manifest: %w", err)
+	}
+
+	return nil
+}
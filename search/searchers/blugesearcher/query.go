@@ -0,0 +1,218 @@
+package blugesearcher
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blugelabs/bluge"
+	"libdb.so/nix-search/search"
+)
+
+// compileQuery compiles a parsed search query into a Bluge query.
+func compileQuery(q search.Query) (bluge.Query, error) {
+	switch q := q.(type) {
+	case search.TermQuery:
+		return compileTermQuery(q), nil
+
+	case search.AndQuery:
+		bq := bluge.NewBooleanQuery()
+		for _, q := range q {
+			// Negations are added as MustNots directly so that they only
+			// filter results instead of also contributing a match-all score.
+			if not, ok := q.(search.NotQuery); ok {
+				sub, err := compileQuery(not.Query)
+				if err != nil {
+					return nil, err
+				}
+				bq.AddMustNot(sub)
+				continue
+			}
+
+			sub, err := compileQuery(q)
+			if err != nil {
+				return nil, err
+			}
+			bq.AddMust(sub)
+		}
+		return bq, nil
+
+	case search.OrQuery:
+		bq := bluge.NewBooleanQuery()
+		bq.SetMinShould(1)
+		for _, q := range q {
+			sub, err := compileQuery(q)
+			if err != nil {
+				return nil, err
+			}
+			bq.AddShould(sub)
+		}
+		return bq, nil
+
+	case search.NotQuery:
+		sub, err := compileQuery(q.Query)
+		if err != nil {
+			return nil, err
+		}
+		return bluge.NewBooleanQuery().AddMustNot(sub), nil
+
+	default:
+		return nil, fmt.Errorf("unknown query type %T", q)
+	}
+}
+
+func compileTermQuery(q search.TermQuery) bluge.Query {
+	if q.Field.IsBool() {
+		return bluge.NewTermQuery(q.Term).SetField(string(q.Field))
+	}
+
+	if q.Field == search.MaintainerField {
+		return newMaintainerQuery(q.Term)
+	}
+
+	if q.Field == search.SystemField {
+		return bluge.NewTermQuery(q.Term).SetField("system")
+	}
+
+	if q.Field == search.ProgramField {
+		return newProgramQuery(q.Term)
+	}
+
+	term := strings.ToLower(q.Term)
+
+	if q.Field == search.PathField {
+		// Paths are indexed with spaces instead of dots.
+		term = strings.ReplaceAll(term, ".", " ")
+		if strings.Contains(term, " ") {
+			q.Phrase = true
+		}
+	}
+
+	if q.Phrase {
+		if q.Field != search.AnyField {
+			return bluge.NewMatchPhraseQuery(term).SetField(string(q.Field))
+		}
+
+		bq := bluge.NewBooleanQuery()
+		bq.SetMinShould(1)
+		bq.AddShould(
+			bluge.NewMatchPhraseQuery(term).SetField("path").SetBoost(4),
+			bluge.NewMatchPhraseQuery(term).SetField("name").SetBoost(2),
+			bluge.NewMatchPhraseQuery(term).SetField("description"),
+		)
+		return bq
+	}
+
+	bq := bluge.NewBooleanQuery()
+	bq.SetMinShould(1)
+
+	if q.Field != search.AnyField {
+		field := string(q.Field)
+		bq.AddShould(
+			bluge.NewTermQuery(term).SetField(field).SetBoost(4),
+			bluge.NewMatchQuery(term).SetField(field).SetBoost(2),
+			bluge.NewWildcardQuery("*"+term+"*").SetField(field),
+		)
+		return bq
+	}
+
+	bq.AddShould(
+		// For exact matches.
+		bluge.NewTermQuery(term).SetField("path").SetBoost(16),
+		bluge.NewTermQuery(term).SetField("name").SetBoost(8),
+		// For full word matches.
+		bluge.NewMatchQuery(term).SetField("path").SetBoost(6),
+		bluge.NewMatchQuery(term).SetField("name").SetBoost(4),
+		bluge.NewMatchQuery(term).SetField("description").SetBoost(2),
+		// For partial substring matches.
+		bluge.NewWildcardQuery("*"+term+"*").SetField("path").SetBoost(4),
+		bluge.NewWildcardQuery("*"+term+"*").SetField("name").SetBoost(2),
+		bluge.NewWildcardQuery("*"+term+"*").SetField("description"),
+		// For fuzzy matches.
+		bluge.NewFuzzyQuery(term).SetField("path").SetBoost(4),
+		bluge.NewFuzzyQuery(term).SetField("name").SetBoost(2),
+		bluge.NewFuzzyQuery(term).SetField("description"),
+	)
+	return bq
+}
+
+// newProgramQuery creates a query for packages providing the given executable.
+// Packages whose main program is exactly the executable rank highest, followed
+// by packages containing the executable, then by partial and fuzzy matches.
+func newProgramQuery(program string) *bluge.BooleanQuery {
+	program = strings.ToLower(program)
+
+	bq := bluge.NewBooleanQuery()
+	bq.SetMinShould(1)
+	bq.AddShould(
+		bluge.NewTermQuery(program).SetField("main_program").SetBoost(16),
+		bluge.NewTermQuery(program).SetField("bin").SetBoost(8),
+		bluge.NewWildcardQuery(program+"*").SetField("bin").SetBoost(2),
+		bluge.NewFuzzyQuery(program).SetField("bin"),
+	)
+	return bq
+}
+
+// newMaintainerQuery creates a query for packages maintained by the given
+// maintainer handle or GitHub username.
+func newMaintainerQuery(maintainer string) *bluge.TermQuery {
+	return bluge.NewTermQuery(strings.ToLower(maintainer)).SetField("maintainer")
+}
+
+// applyFilters wraps the given query with the filters in opts. If no filters
+// are set, the query is returned as-is.
+func applyFilters(q bluge.Query, opts search.Opts) bluge.Query {
+	filters := []struct {
+		field  string
+		filter search.Filter
+	}{
+		{"unfree", opts.Unfree},
+		{"broken", opts.Broken},
+		{"unsupported", opts.UnsupportedPlatform},
+		{"overlay", opts.Overlay},
+	}
+
+	var bq *bluge.BooleanQuery
+	if opts.Program != "" {
+		bq = bluge.NewBooleanQuery().AddMust(q, newProgramQuery(opts.Program))
+	}
+
+	if opts.Maintainer != "" {
+		if bq == nil {
+			bq = bluge.NewBooleanQuery().AddMust(q)
+		}
+		// Zero the boost so that the maintainer does not affect scoring.
+		bq.AddMust(newMaintainerQuery(opts.Maintainer).SetBoost(0))
+	}
+
+	for _, system := range opts.Systems {
+		if bq == nil {
+			bq = bluge.NewBooleanQuery().AddMust(q)
+		}
+		bq.AddMust(bluge.NewTermQuery(system).SetField("system").SetBoost(0))
+	}
+
+	for _, f := range filters {
+		if f.filter == search.FilterInclude {
+			continue
+		}
+
+		if bq == nil {
+			bq = bluge.NewBooleanQuery().AddMust(q)
+		}
+
+		// Zero the boost so that filters do not affect scoring.
+		term := bluge.NewTermQuery("true").SetField(f.field).SetBoost(0)
+
+		switch f.filter {
+		case search.FilterExclude:
+			bq.AddMustNot(term)
+		case search.FilterOnly:
+			bq.AddMust(term)
+		}
+	}
+
+	if bq == nil {
+		return q
+	}
+	return bq
+}
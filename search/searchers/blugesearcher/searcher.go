@@ -3,6 +3,7 @@ package blugesearcher
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"iter"
 	"os"
@@ -88,47 +89,80 @@ func (s *PackagesSearcher) SearchPackages(ctx context.Context, query string, opt
 		}
 	}
 
-	searchQuery := bluge.NewBooleanQuery()
-	searchQuery.SetMinShould(1)
+	var searchQuery bluge.Query
+	// exactQuery is the query that the Exact filter applies to. It is nil if
+	// everything matches.
+	var exactQuery search.Query
 
 	if opts.Regex {
-		searchQuery.AddShould(
+		regexQuery := bluge.NewBooleanQuery()
+		regexQuery.SetMinShould(1)
+		regexQuery.AddShould(
 			bluge.NewRegexpQuery(query).SetField("name").SetBoost(2),
 			bluge.NewRegexpQuery(query).SetField("description"),
 		)
+		searchQuery = regexQuery
+		exactQuery = search.TermQuery{Term: query}
+	} else if strings.TrimSpace(query) == "" {
+		// Match everything, leaving it to applyFilters to narrow the results
+		// down, e.g. by program.
+		searchQuery = bluge.NewMatchAllQuery()
 	} else {
-		searchQuery.AddShould(
-			// For exact matches.
-			bluge.NewTermQuery(query).SetField("path").SetBoost(16),
-			bluge.NewTermQuery(query).SetField("name").SetBoost(8),
-			// For full word matches.
-			bluge.NewMatchQuery(query).SetField("path").SetBoost(6),
-			bluge.NewMatchQuery(query).SetField("name").SetBoost(4),
-			bluge.NewMatchQuery(query).SetField("description").SetBoost(2),
-			// For partial substring matches.
-			bluge.NewWildcardQuery("*"+query+"*").SetField("path").SetBoost(4),
-			bluge.NewWildcardQuery("*"+query+"*").SetField("name").SetBoost(2),
-			bluge.NewWildcardQuery("*"+query+"*").SetField("description"),
-			// For fuzzy matches.
-			bluge.NewFuzzyQuery(query).SetField("path").SetBoost(4),
-			bluge.NewFuzzyQuery(query).SetField("name").SetBoost(2),
-			bluge.NewFuzzyQuery(query).SetField("description"),
-		)
+		parsed, err := search.ParseQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse query: %w", err)
+		}
+
+		searchQuery, err = compileQuery(parsed)
+		if err != nil {
+			return nil, fmt.Errorf("cannot compile query: %w", err)
+		}
+
+		exactQuery = parsed
 	}
 
+	searchQuery = applyFilters(searchQuery, opts)
+
 	log := hclog.FromContext(ctx)
 	log.Debug("searching", "query", query)
 
-	request := bluge.NewAllMatches(searchQuery).
-		WithStandardAggregations().
-		IncludeLocations()
+	// The Exact filter is applied after Bluge returns its results, so Bluge
+	// cannot paginate for us in that case.
+	offset, limit := opts.Offset, opts.Limit
+	if opts.Exact {
+		offset, limit = 0, 0
+	}
+
+	var request bluge.SearchRequest
+	if opts.SortBy == search.SortByNone && opts.Program == "" && offset <= 0 && limit <= 0 {
+		// Nothing needs to be sorted, so stream the matches as they are found
+		// instead of collecting all of them first. Packages providing a
+		// program are still ranked by how exactly they provide it.
+		request = bluge.NewAllMatches(searchQuery).
+			WithStandardAggregations().
+			IncludeLocations()
+	} else {
+		if limit <= 0 {
+			count, err := s.reader.Count()
+			if err != nil {
+				return nil, fmt.Errorf("cannot count documents: %w", err)
+			}
+			limit = max(int(count), 1)
+		}
+
+		request = bluge.NewTopNSearch(limit, searchQuery).
+			SetFrom(max(offset, 0)).
+			SortBy(sortFields(opts.SortBy)).
+			WithStandardAggregations().
+			IncludeLocations()
+	}
 
 	matchIter, err := s.reader.Search(ctx, request)
 	if err != nil {
 		return nil, fmt.Errorf("cannot search: %w", err)
 	}
 
-	return func(yield func(p search.SearchedPackage) bool) {
+	seq := func(yield func(p search.SearchedPackage) bool) {
 		var locationBuf []blugesearch.Location
 
 		for {
@@ -142,77 +176,18 @@ func (s *PackagesSearcher) SearchPackages(ctx context.Context, query string, opt
 				break
 			}
 
-			var path string
-			var jsonData []byte
-			err = match.VisitStoredFields(func(field string, value []byte) bool {
-				switch field {
-				case "_id": // ID has same length as .path but is more correct
-					path = string(value)
-				case "json":
-					jsonData = value
-				}
-				return path == "" || len(jsonData) == 0
-			})
+			result, err := matchedPackage(match)
 			if err != nil {
-				log.Error("cannot visit stored fields", "error", err)
-				continue
-			}
-
-			var pkg search.Package
-			if err := json.Unmarshal(jsonData, &pkg); err != nil {
-				log.Error("cannot unmarshal package", "id", path, "error", err)
+				log.Error("cannot decode matched package", "error", err)
 				continue
 			}
 
-			result := search.SearchedPackage{
-				Package: pkg,
-				Path:    path,
-			}
-
 			if highlighter != nil {
 				locationBuf = match.Complete(locationBuf)
 			}
 
-			if opts.Exact {
-				for i, possible := range []string{
-					result.Path,
-					result.Name,
-					result.Description,
-				} {
-					start := strings.Index(possible, query)
-					if start == -1 {
-						continue
-					}
-
-					// Edit the highlighted location directly, if needed.
-					if highlighter != nil {
-						end := start + len(query)
-
-						termMap := blugesearch.TermLocationMap{
-							query: {
-								&blugesearch.Location{
-									Pos:   0,
-									Start: start,
-									End:   end,
-								},
-							},
-						}
-
-						switch i {
-						case 0:
-							match.Locations["path"] = termMap
-						case 1:
-							match.Locations["name"] = termMap
-						case 2:
-							match.Locations["description"] = termMap
-						}
-					}
-
-					goto ok
-				}
-
+			if opts.Exact && !matchesExactly(match, highlighter != nil, result, exactQuery) {
 				continue
-			ok:
 			}
 
 			if highlighter != nil {
@@ -224,9 +199,229 @@ func (s *PackagesSearcher) SearchPackages(ctx context.Context, query string, opt
 				return
 			}
 		}
+	}
+
+	if opts.Exact {
+		return paginate(seq, opts.Offset, opts.Limit), nil
+	}
+	return seq, nil
+}
+
+// PackageByPath returns the package at exactly the given path. If there is no
+// such package, search.ErrPackageNotFound is returned.
+func (s *PackagesSearcher) PackageByPath(ctx context.Context, path search.Path) (search.SearchedPackage, error) {
+	query := bluge.NewTermQuery(path.String()).SetField("_id")
+
+	matchIter, err := s.reader.Search(ctx, bluge.NewTopNSearch(1, query))
+	if err != nil {
+		return search.SearchedPackage{}, fmt.Errorf("cannot search: %w", err)
+	}
+
+	match, err := matchIter.Next()
+	if err != nil {
+		return search.SearchedPackage{}, fmt.Errorf("cannot iterate matches: %w", err)
+	}
+
+	if match == nil {
+		return search.SearchedPackage{}, search.ErrPackageNotFound
+	}
+
+	pkg, err := matchedPackage(match)
+	// The score of an exact lookup is meaningless.
+	pkg.Score = 0
+	return pkg, err
+}
+
+// ReadPackages reads all packages stored in the index at the given path back
+// into a package set. If path is empty, the default path is used.
+func ReadPackages(ctx context.Context, path string) (search.TopLevelPackages, error) {
+	manifest, err := ReadManifest(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return search.TopLevelPackages{}, err
+	}
+
+	searcher, err := Open(path)
+	if err != nil {
+		return search.TopLevelPackages{}, err
+	}
+	defer searcher.Close()
+
+	matchIter, err := searcher.reader.Search(ctx, bluge.NewAllMatches(bluge.NewMatchAllQuery()))
+	if err != nil {
+		return search.TopLevelPackages{}, fmt.Errorf("cannot search: %w", err)
+	}
+
+	packages := search.TopLevelPackages{
+		PackageSet: make(search.PackageSet),
+		Nixpkgs:    manifest.Name,
+		Flake:      manifest.Flake,
+		Info:       manifest.SourceInfo,
+	}
+
+	for {
+		match, err := matchIter.Next()
+		if err != nil {
+			return search.TopLevelPackages{}, fmt.Errorf("cannot iterate matches: %w", err)
+		}
+
+		if match == nil {
+			break
+		}
+
+		pkg, err := matchedPackage(match)
+		if err != nil {
+			return search.TopLevelPackages{}, err
+		}
+
+		// Indexes without a manifest do not know their top-level name, so
+		// guess it from the first package.
+		if packages.Nixpkgs == "" {
+			packages.Nixpkgs, _, packages.Flake = strings.Cut(pkg.Path, "#")
+			if !packages.Flake {
+				packages.Nixpkgs, _, _ = strings.Cut(pkg.Path, ".")
+			}
+		}
+
+		rest, ok := strings.CutPrefix(pkg.Path, packages.Nixpkgs)
+		if !ok || len(rest) < 2 {
+			return search.TopLevelPackages{}, fmt.Errorf("package %q is not in %q", pkg.Path, packages.Nixpkgs)
+		}
+
+		packages.Insert(strings.Split(rest[1:], "."), pkg.Package)
+	}
+
+	return packages, nil
+}
+
+// matchedPackage decodes the package stored in the given document match.
+func matchedPackage(match *blugesearch.DocumentMatch) (search.SearchedPackage, error) {
+	var path string
+	var jsonData []byte
+	err := match.VisitStoredFields(func(field string, value []byte) bool {
+		switch field {
+		case "_id": // ID has same length as .path but is more correct
+			path = string(value)
+		case "json":
+			jsonData = value
+		}
+		return path == "" || len(jsonData) == 0
+	})
+	if err != nil {
+		return search.SearchedPackage{}, fmt.Errorf("cannot visit stored fields: %w", err)
+	}
+
+	var pkg search.Package
+	if err := json.Unmarshal(jsonData, &pkg); err != nil {
+		return search.SearchedPackage{}, fmt.Errorf("cannot unmarshal package %q: %w", path, err)
+	}
+
+	return search.SearchedPackage{
+		Package: pkg,
+		Path:    path,
+		Score:   match.Score,
 	}, nil
 }
 
+// matchesExactly returns true if the free terms of the query appear verbatim
+// in the path, name or description of the package, where all of the terms in
+// an AND and any of the terms in an OR have to. Terms restricted to a field
+// and negated terms were already checked by Bluge. If highlight is true, the
+// highlighted locations of the match are overridden with the verbatim
+// locations.
+func matchesExactly(match *blugesearch.DocumentMatch, highlight bool, result search.SearchedPackage, q search.Query) bool {
+	locations, ok := exactLocations(q, result)
+	if !ok {
+		return false
+	}
+
+	if !highlight || len(locations) == 0 {
+		return true
+	}
+
+	if match.Locations == nil {
+		match.Locations = make(blugesearch.FieldTermLocationMap)
+	}
+
+	// Fields whose Bluge locations were already replaced by exact ones, so
+	// that the locations of later terms are added to them.
+	replaced := make(map[string]bool, 3)
+
+	for _, loc := range locations {
+		if !replaced[loc.field] {
+			match.Locations[loc.field] = make(blugesearch.TermLocationMap)
+			replaced[loc.field] = true
+		}
+
+		termMap := match.Locations[loc.field]
+		termMap[loc.term] = append(termMap[loc.term], &blugesearch.Location{
+			Pos:   0,
+			Start: loc.start,
+			End:   loc.start + len(loc.term),
+		})
+	}
+
+	return true
+}
+
+// exactLocation is where a free term of a query appears verbatim.
+type exactLocation struct {
+	field string
+	term  string
+	start int
+}
+
+// exactLocations returns the locations of the free terms of q that appear
+// verbatim in the package, and whether q matches exactly. Only the branches of
+// an OR that match contribute locations.
+func exactLocations(q search.Query, result search.SearchedPackage) ([]exactLocation, bool) {
+	switch q := q.(type) {
+	case search.TermQuery:
+		if q.Field != search.AnyField {
+			return nil, true
+		}
+
+		for _, possible := range []struct {
+			field string
+			value string
+		}{
+			{"path", result.Path},
+			{"name", result.Name},
+			{"description", result.Description},
+		} {
+			if start := strings.Index(possible.value, q.Term); start != -1 {
+				return []exactLocation{{possible.field, q.Term, start}}, true
+			}
+		}
+		return nil, false
+
+	case search.AndQuery:
+		var locations []exactLocation
+		for _, q := range q {
+			l, ok := exactLocations(q, result)
+			if !ok {
+				return nil, false
+			}
+			locations = append(locations, l...)
+		}
+		return locations, true
+
+	case search.OrQuery:
+		var locations []exactLocation
+		var matched bool
+		for _, q := range q {
+			if l, ok := exactLocations(q, result); ok {
+				locations = append(locations, l...)
+				matched = true
+			}
+		}
+		return locations, matched
+
+	default:
+		// NotQuery, or no query at all.
+		return nil, true
+	}
+}
+
 func highlightPackage(match *blugesearch.DocumentMatch, highlighter blugehighlight.Highlighter, pkg search.SearchedPackage) search.SearchedPackage {
 	highlighted := pkg
 	highlighted.Name = highlighter.BestFragment(match.Locations["name"], []byte(pkg.Name))
@@ -0,0 +1,111 @@
+package blugesearcher
+
+import (
+	"cmp"
+	"iter"
+	"strings"
+
+	"libdb.so/nix-search/search"
+)
+
+// sortFields returns the Bluge sort order for the given sort order. Ties are
+// broken by the package path so that results are stable across searches.
+func sortFields(order search.SortOrder) []string {
+	switch order {
+	case search.SortByName:
+		return []string{"sort_name", "_id"}
+	case search.SortByPath:
+		return []string{"_id"}
+	case search.SortByVersion:
+		return []string{"sort_version", "_id"}
+	default:
+		return []string{"-_score", "_id"}
+	}
+}
+
+// compareResults returns a comparison function that orders search results the
+// same way that sortFields orders them within Bluge.
+func compareResults(order search.SortOrder) func(a, b search.SearchedPackage) int {
+	switch order {
+	case search.SortByName:
+		return func(a, b search.SearchedPackage) int {
+			return cmp.Or(
+				cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)),
+				cmp.Compare(a.Path, b.Path),
+			)
+		}
+	case search.SortByPath:
+		return func(a, b search.SearchedPackage) int {
+			return cmp.Compare(a.Path, b.Path)
+		}
+	case search.SortByVersion:
+		return func(a, b search.SearchedPackage) int {
+			return cmp.Or(
+				cmp.Compare(versionSortKey(a.Version), versionSortKey(b.Version)),
+				cmp.Compare(a.Path, b.Path),
+			)
+		}
+	default:
+		return func(a, b search.SearchedPackage) int {
+			return cmp.Or(
+				cmp.Compare(b.Score, a.Score),
+				cmp.Compare(a.Path, b.Path),
+			)
+		}
+	}
+}
+
+// versionSortKey returns a key for the given version that sorts numbers
+// numerically, e.g. 1.9 before 1.10, by zero-padding every run of digits.
+func versionSortKey(version string) string {
+	const width = 10
+
+	var b strings.Builder
+	b.Grow(len(version))
+
+	for len(version) > 0 {
+		n := strings.IndexFunc(version, func(r rune) bool { return r < '0' || r > '9' })
+		if n == -1 {
+			n = len(version)
+		}
+
+		if n == 0 {
+			b.WriteByte(version[0])
+			version = version[1:]
+			continue
+		}
+
+		digits := strings.TrimLeft(version[:n], "0")
+		if len(digits) < width {
+			b.WriteString(strings.Repeat("0", width-len(digits)))
+		}
+		b.WriteString(digits)
+		version = version[n:]
+	}
+
+	return strings.ToLower(b.String())
+}
+
+// paginate skips the first offset results of seq and stops after limit
+// results. If limit is 0, all remaining results are yielded.
+func paginate(seq iter.Seq[search.SearchedPackage], offset, limit int) iter.Seq[search.SearchedPackage] {
+	if offset <= 0 && limit <= 0 {
+		return seq
+	}
+
+	return func(yield func(search.SearchedPackage) bool) {
+		skip, n := offset, 0
+		for pkg := range seq {
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if !yield(pkg) {
+				return
+			}
+			if n++; n == limit {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package blugesearcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"libdb.so/nix-search/search"
+)
+
+// Sources is a directory of multiple named indexes, such as different
+// channels or flakes. Each source is a separate index that can be searched
+// individually or together with the others.
+type Sources struct {
+	path string
+}
+
+// OpenSources opens the sources directory at the given path. If path is
+// empty, the default path is used.
+func OpenSources(path string) (*Sources, error) {
+	if path == "" {
+		var err error
+
+		path, err = defaultIndexPath()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get default index path: %w", err)
+		}
+	}
+
+	return &Sources{path}, nil
+}
+
+// Path returns the path to the sources directory.
+func (s *Sources) Path() string {
+	return s.path
+}
+
+// SourcePath returns the path to the index of the named source. The returned
+// path can be given to IndexPackages, UpdatePackages and Open.
+func (s *Sources) SourcePath(name string) string {
+	return filepath.Join(s.path, "sources", url.PathEscape(name))
+}
+
+// Names returns the names of all sources that have an index, sorted.
+func (s *Sources) Names() ([]string, error) {
+	return s.names(s.Exists)
+}
+
+// Outdated returns the names of all sources that only have an index written
+// by an older version of nix-search, sorted. These sources are not part of
+// Names and cannot be searched until they are indexed again.
+func (s *Sources) Outdated() ([]string, error) {
+	return s.names(func(name string) bool {
+		if s.Exists(name) {
+			return false
+		}
+		for _, version := range indexVersions[:len(indexVersions)-1] {
+			if _, err := os.Stat(filepath.Join(s.SourcePath(name), version)); err == nil {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// names returns the names of all source directories for which keep returns
+// true, sorted.
+func (s *Sources) names(keep func(name string) bool) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.path, "sources"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot read sources: %w", err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		name, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		if keep(name) {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+	return names, nil
+}
+
+// Exists checks if the named source has an index.
+func (s *Sources) Exists(name string) bool {
+	return Exists(s.SourcePath(name))
+}
+
+// Manifest reads the manifest of the named source.
+func (s *Sources) Manifest(name string) (Manifest, error) {
+	return ReadManifest(s.SourcePath(name))
+}
+
+// SetStale records the result of checking the named source for being stale at
+// the given time in its manifest, so that the check does not have to be
+// repeated for every search. Nothing is recorded while the source is being
+// indexed, since the manifest is about to be replaced.
+func (s *Sources) SetStale(name string, stale bool, checkedAt time.Time) error {
+	unlock, err := s.Lock(name, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	manifest, err := s.Manifest(name)
+	if err != nil {
+		return err
+	}
+
+	manifest.Stale = stale
+	manifest.CheckedAt = checkedAt
+
+	return writeManifest(filepath.Join(s.SourcePath(name), lastIndexVersion), manifest)
+}
+
+// Index indexes the given packages into the named source, replacing its
+// previous index.
+func (s *Sources) Index(ctx context.Context, name string, packages search.TopLevelPackages) error {
+	if err := IndexPackages(ctx, s.SourcePath(name), packages); err != nil {
+		return err
+	}
+
+	// Older versions of nix-search kept a single index directly within the
+	// directory. Clean those up now that we have a source.
+	if err := cleanOldIndexFolders(s.path); err != nil {
+		log := hclog.FromContext(ctx)
+		log.Warn("cannot clean old index folders", "path", s.path, "error", err)
+	}
+
+	return nil
+}
+
+// NewWriter creates an IndexWriter that replaces the index of the named source
+// once committed.
+func (s *Sources) NewWriter(ctx context.Context, name string) (*IndexWriter, error) {
+	// See Index.
+	if err := cleanOldIndexFolders(s.path); err != nil {
+		log := hclog.FromContext(ctx)
+		log.Warn("cannot clean old index folders", "path", s.path, "error", err)
+	}
+
+	return NewIndexWriter(s.SourcePath(name))
+}
+
+// Update incrementally updates the named source to contain the given
+// packages. See UpdatePackages.
+func (s *Sources) Update(ctx context.Context, name string, packages search.TopLevelPackages) (IndexReport, error) {
+	return UpdatePackages(ctx, s.SourcePath(name), packages)
+}
+
+// Open opens a searcher over the named sources. If no names are given, all
+// sources are opened.
+func (s *Sources) Open(names ...string) (*MultiSearcher, error) {
+	if len(names) == 0 {
+		var err error
+
+		names, err = s.Names()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no indexed sources")
+		}
+	}
+
+	m := &MultiSearcher{
+		searchers: make([]namedSearcher, 0, len(names)),
+	}
+
+	for _, name := range names {
+		searcher, err := Open(s.SourcePath(name))
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("cannot open source %q: %w", name, err)
+		}
+
+		m.searchers = append(m.searchers, namedSearcher{name, searcher})
+	}
+
+	return m, nil
+}
+
+// MultiSearcher searches multiple sources at once. It implements
+// search.PackagesSearcher.
+type MultiSearcher struct {
+	searchers []namedSearcher
+}
+
+type namedSearcher struct {
+	name string
+	*PackagesSearcher
+}
+
+var _ search.PackagesSearcher = (*MultiSearcher)(nil)
+
+// Names returns the names of the sources that are being searched.
+func (m *MultiSearcher) Names() []string {
+	names := make([]string, len(m.searchers))
+	for i, s := range m.searchers {
+		names[i] = s.name
+	}
+	return names
+}
+
+// Close closes all sources.
+func (m *MultiSearcher) Close() error {
+	var errs []error
+	for _, s := range m.searchers {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// SearchPackages implements search.PackagesSearcher. Results from all sources
+// are merged according to opts.SortBy, or returned one source after another if
+// they are not sorted, and each result has its Source set.
+func (m *MultiSearcher) SearchPackages(ctx context.Context, query string, opts search.Opts) (iter.Seq[search.SearchedPackage], error) {
+	if len(m.searchers) == 1 {
+		s := m.searchers[0]
+		seq, err := s.SearchPackages(ctx, query, opts)
+		if err != nil {
+			return nil, fmt.Errorf("cannot search source %q: %w", s.name, err)
+		}
+		return withSource(seq, s.name), nil
+	}
+
+	// Any source may have the results on the requested page, so each source
+	// must return everything up to the end of it.
+	sourceOpts := opts
+	sourceOpts.Offset = 0
+	if opts.Limit > 0 {
+		sourceOpts.Limit = max(opts.Offset, 0) + opts.Limit
+	}
+
+	seqs := make([]iter.Seq[search.SearchedPackage], len(m.searchers))
+	for i, s := range m.searchers {
+		seq, err := s.SearchPackages(ctx, query, sourceOpts)
+		if err != nil {
+			return nil, fmt.Errorf("cannot search source %q: %w", s.name, err)
+		}
+		seqs[i] = withSource(seq, s.name)
+	}
+
+	var merged iter.Seq[search.SearchedPackage]
+	if opts.SortBy == search.SortByNone && opts.Program == "" && opts.Limit <= 0 {
+		merged = concat(seqs)
+	} else {
+		merged = mergeSorted(seqs, compareResults(opts.SortBy))
+	}
+
+	return paginate(merged, opts.Offset, opts.Limit), nil
+}
+
+// PackageByPath returns the package at exactly the given path from the first
+// source that has it. If no source has it, search.ErrPackageNotFound is
+// returned.
+func (m *MultiSearcher) PackageByPath(ctx context.Context, path search.Path) (search.SearchedPackage, error) {
+	for _, s := range m.searchers {
+		pkg, err := s.PackageByPath(ctx, path)
+		if err != nil {
+			if errors.Is(err, search.ErrPackageNotFound) {
+				continue
+			}
+			return search.SearchedPackage{}, fmt.Errorf("cannot look up source %q: %w", s.name, err)
+		}
+
+		pkg.Source = s.name
+		return pkg, nil
+	}
+
+	return search.SearchedPackage{}, search.ErrPackageNotFound
+}
+
+func withSource(seq iter.Seq[search.SearchedPackage], source string) iter.Seq[search.SearchedPackage] {
+	return func(yield func(search.SearchedPackage) bool) {
+		for pkg := range seq {
+			pkg.Source = source
+			if pkg.Highlighted != nil {
+				pkg.Highlighted.Source = source
+			}
+			if !yield(pkg) {
+				return
+			}
+		}
+	}
+}
+
+// concat yields the results of each sequence in turn.
+func concat(seqs []iter.Seq[search.SearchedPackage]) iter.Seq[search.SearchedPackage] {
+	return func(yield func(search.SearchedPackage) bool) {
+		for _, seq := range seqs {
+			for pkg := range seq {
+				if !yield(pkg) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// mergeSorted merges the given sequences, each already sorted by compare,
+// into a single sorted sequence. Ties are yielded in the order of seqs.
+func mergeSorted(seqs []iter.Seq[search.SearchedPackage], compare func(a, b search.SearchedPackage) int) iter.Seq[search.SearchedPackage] {
+	return func(yield func(search.SearchedPackage) bool) {
+		type head struct {
+			pkg  search.SearchedPackage
+			next func() (search.SearchedPackage, bool)
+		}
+
+		heads := make([]head, 0, len(seqs))
+		for _, seq := range seqs {
+			next, stop := iter.Pull(seq)
+			defer stop()
+
+			if pkg, ok := next(); ok {
+				heads = append(heads, head{pkg, next})
+			}
+		}
+
+		for len(heads) > 0 {
+			i := 0
+			for j := 1; j < len(heads); j++ {
+				if compare(heads[j].pkg, heads[i].pkg) < 0 {
+					i = j
+				}
+			}
+
+			if !yield(heads[i].pkg) {
+				return
+			}
+
+			if pkg, ok := heads[i].next(); ok {
+				heads[i].pkg = pkg
+			} else {
+				heads = slices.Delete(heads, i, i+1)
+			}
+		}
+	}
+}
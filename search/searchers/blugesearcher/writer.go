@@ -0,0 +1,220 @@
+package blugesearcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/blugelabs/bluge"
+	"github.com/hashicorp/go-hclog"
+	"libdb.so/nix-search/search"
+
+	blugeindex "github.com/blugelabs/bluge/index"
+)
+
+// indexBatchSize is the number of packages that IndexWriter writes at once.
+// It bounds how many packages are held in memory while indexing.
+const indexBatchSize = 2000
+
+// IndexWriter builds a new index out of packages as they are added, so that
+// packages can be indexed while they are still being evaluated. Packages are
+// written in bounded batches in the background. The new index only replaces
+// the existing one once Commit is called.
+type IndexWriter struct {
+	path    string
+	newPath string
+
+	writer  *bluge.Writer
+	batch   *blugeindex.Batch
+	batches chan *blugeindex.Batch
+	wg      sync.WaitGroup
+
+	mu  sync.Mutex
+	err error // first error of the background writer, guarded by mu
+
+	count    int // packages in batch
+	packages int
+	sets     int
+	closed   bool
+}
+
+// NewIndexWriter creates a writer for a new index at the given path. If path
+// is empty, the default path is used. The writer must be closed.
+func NewIndexWriter(path string) (*IndexWriter, error) {
+	if path == "" {
+		var err error
+
+		path, err = defaultIndexPath()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get default index path: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create index directory: %w", err)
+	}
+
+	newPath, err := os.MkdirTemp(path, "index-tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create new index snapshot: %w", err)
+	}
+
+	writer, err := bluge.OpenWriter(bluge.DefaultConfig(newPath))
+	if err != nil {
+		os.RemoveAll(newPath)
+		return nil, fmt.Errorf("cannot open bluge writer: %w", err)
+	}
+
+	w := &IndexWriter{
+		path:    path,
+		newPath: newPath,
+		writer:  writer,
+		batch:   bluge.NewBatch(),
+		batches: make(chan *blugeindex.Batch, 1),
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for batch := range w.batches {
+			if w.error() != nil {
+				continue // drain
+			}
+			if err := writer.Batch(batch); err != nil {
+				w.setError(fmt.Errorf("cannot batch index: %w", err))
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+// Add adds a package to the index. Package sets are only counted. Add has the
+// signature expected by search.StreamPackages. Once writing in the background
+// has failed, Add returns that error so that indexing stops early.
+func (w *IndexWriter) Add(path search.Path, drv search.Derivation) error {
+	if err := w.error(); err != nil {
+		return err
+	}
+
+	switch drv := drv.(type) {
+	case search.Package:
+		doc := newPackageDocument(path, drv, packageJSON(drv))
+		w.batch.Update(doc.ID(), doc)
+		w.packages++
+		w.count++
+	case search.PackageSet:
+		w.sets++
+	}
+
+	if w.count >= indexBatchSize {
+		w.flush()
+	}
+
+	return nil
+}
+
+// addPackageSet adds all packages and package sets in the given set.
+func (w *IndexWriter) addPackageSet(path search.Path, set search.PackageSet) error {
+	for name, drv := range set {
+		if err := w.Add(path.Push(name), drv); err != nil {
+			return err
+		}
+		if set, ok := drv.(search.PackageSet); ok {
+			if err := w.addPackageSet(path.Push(name), set); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flush hands the current batch to the background writer. It blocks while
+// the background writer is still busy with an earlier batch.
+func (w *IndexWriter) flush() {
+	if w.count == 0 {
+		return
+	}
+	w.batches <- w.batch
+	w.batch = bluge.NewBatch()
+	w.count = 0
+}
+
+func (w *IndexWriter) error() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// setError records err unless an error was already recorded.
+func (w *IndexWriter) setError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// finish writes the remaining batch and waits for the background writer.
+func (w *IndexWriter) finish() error {
+	if w.closed {
+		return w.error()
+	}
+	w.closed = true
+
+	w.flush()
+	close(w.batches)
+	w.wg.Wait()
+
+	if err := w.writer.Close(); err != nil {
+		w.setError(fmt.Errorf("cannot close index: %w", err))
+	}
+
+	return w.error()
+}
+
+// Commit finishes writing and atomically replaces the existing index with the
+// new one. The name and info of packages are recorded in the manifest; its
+// packages are ignored, since they are expected to have been added already.
+// The manifest of the new index is returned.
+func (w *IndexWriter) Commit(ctx context.Context, packages search.TopLevelPackages) (Manifest, error) {
+	if err := w.finish(); err != nil {
+		return Manifest{}, err
+	}
+
+	manifest := Manifest{
+		SourceInfo: packages.Info,
+		Name:       packages.Nixpkgs,
+		Packages:   w.packages,
+		Sets:       w.sets,
+		Version:    search.Version(),
+	}
+
+	if err := writeManifest(w.newPath, manifest); err != nil {
+		return Manifest{}, err
+	}
+
+	if err := swapDir(w.path, lastIndexVersion, filepath.Base(w.newPath)); err != nil {
+		return Manifest{}, fmt.Errorf("cannot commit new index: %w", err)
+	}
+
+	if err := os.RemoveAll(w.newPath); err != nil {
+		log := hclog.FromContext(ctx)
+		log.Error("cannot remove new index snapshot", "path", w.newPath, "error", err)
+	}
+
+	if err := cleanOldIndexFolders(w.path); err != nil {
+		log := hclog.FromContext(ctx)
+		log.Warn("cannot clean old index folders", "path", w.path, "error", err)
+	}
+
+	return manifest, nil
+}
+
+// Close discards the new index unless it was committed.
+func (w *IndexWriter) Close() error {
+	w.finish()
+	return os.RemoveAll(w.newPath)
+}
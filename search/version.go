@@ -0,0 +1,24 @@
+package search
+
+import "runtime/debug"
+
+// Version returns the version of nix-search as recorded in the binary's build
+// information. If the version is unknown, "(devel)" is returned.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+
+	return "(devel)"
+}